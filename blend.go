@@ -0,0 +1,165 @@
+package tilemerge
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// BlendMode sets how a layer is blended with the layers below it.
+// Blend modes other than BlendNormal follow the separable blend modes of the
+// W3C Compositing and Blending specification, and are composited using
+// source-over.
+type BlendMode int
+
+const (
+	// BlendNormal draws a layer over the layers below it
+	BlendNormal BlendMode = iota
+	// BlendMultiply multiplies the colors of the layers, which darkens the
+	// layers below; useful for hillshades
+	BlendMultiply
+	// BlendScreen inverts, multiplies and inverts the colors of the layers,
+	// which lightens the layers below
+	BlendScreen
+	// BlendOverlay multiplies or screens the colors of the layer depending on
+	// the colors of the layers below, preserving highlights and shadows below
+	BlendOverlay
+	// BlendSoftLight darkens or lightens the colors of the layers below
+	// depending on the colors of the layer, like a diffused spotlight
+	BlendSoftLight
+)
+
+// Draw blends src over dst within r, aligning r.Min in dst with sp in src,
+// like draw.Draw with draw.Over.  The alpha of src is multiplied by opacity,
+// which ranges from 0 (transparent) to 1 (opaque).
+func (m BlendMode) Draw(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point, opacity float64) {
+	if opacity <= 0 {
+		return
+	}
+	if opacity > 1 {
+		opacity = 1
+	}
+
+	if m == BlendNormal {
+		var mask image.Image
+		if opacity < 1 {
+			mask = image.NewUniform(color.Alpha{uint8(math.Floor(opacity*255 + 0.5))})
+		}
+		draw.DrawMask(dst, r, src, sp, mask, image.ZP, draw.Over)
+		return
+	}
+
+	r = r.Intersect(dst.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		sy := sp.Y + y - r.Min.Y
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sx := sp.X + x - r.Min.X
+			if !(image.Point{sx, sy}.In(src.Bounds())) {
+				continue
+			}
+
+			sr, sg, sb, sa := nrgbaAt(src, sx, sy)
+			sa *= opacity
+			if sa == 0 {
+				continue
+			}
+			br, bg, bb, ba := nrgbaAt(dst, x, y)
+
+			// premultiplied result
+			cr := m.composite(br, sr, ba, sa)
+			cg := m.composite(bg, sg, ba, sa)
+			cb := m.composite(bb, sb, ba, sa)
+			ca := sa + ba*(1-sa)
+
+			setPremultiplied(dst, x, y, cr, cg, cb, ca)
+		}
+	}
+}
+
+// composite returns the premultiplied result of blending the non-premultiplied
+// source color cs with alpha as over the backdrop color cb with alpha ab
+func (m BlendMode) composite(cb, cs, ab, as float64) float64 {
+	blended := (1-ab)*cs + ab*m.blend(cb, cs)
+	return as*blended + (1-as)*ab*cb
+}
+
+// blend returns the result of blending the non-premultiplied source color
+// cs with the backdrop color cb
+func (m BlendMode) blend(cb, cs float64) float64 {
+	switch m {
+	case BlendMultiply:
+		return cb * cs
+	case BlendScreen:
+		return cb + cs - cb*cs
+	case BlendOverlay:
+		// hard light with the layers swapped
+		if cb <= 0.5 {
+			return 2 * cs * cb
+		}
+		return BlendScreen.blend(cs, 2*cb-1)
+	case BlendSoftLight:
+		if cs <= 0.5 {
+			return cb - (1-2*cs)*cb*(1-cb)
+		}
+		var d float64
+		if cb <= 0.25 {
+			d = ((16*cb-12)*cb + 4) * cb
+		} else {
+			d = math.Sqrt(cb)
+		}
+		return cb + (2*cs-1)*(d-cb)
+	}
+	return cs
+}
+
+// nrgbaAt returns the non-premultiplied color of the pixel at x, y, scaled
+// from 0 to 1.  8-bit RGBA and NRGBA images are read directly to avoid
+// rounding the color of partially transparent pixels.
+func nrgbaAt(img image.Image, x, y int) (r, g, b, a float64) {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		c := img.NRGBAAt(x, y)
+		return float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255, float64(c.A) / 255
+	case *image.RGBA:
+		c := img.RGBAAt(x, y)
+		if c.A == 0 {
+			return 0, 0, 0, 0
+		}
+		a := float64(c.A)
+		return float64(c.R) / a, float64(c.G) / a, float64(c.B) / a, a / 255
+	}
+
+	pr, pg, pb, pa := img.At(x, y).RGBA()
+	if pa == 0 {
+		return 0, 0, 0, 0
+	}
+	a = float64(pa)
+	return float64(pr) / a, float64(pg) / a, float64(pb) / a, a / 0xffff
+}
+
+// setPremultiplied sets the pixel at x, y to the premultiplied color r, g, b, a,
+// scaled from 0 to 1
+func setPremultiplied(img draw.Image, x, y int, r, g, b, a float64) {
+	switch img := img.(type) {
+	case *image.RGBA:
+		img.SetRGBA(x, y, color.RGBA{to8(r), to8(g), to8(b), to8(a)})
+		return
+	case *image.NRGBA:
+		if a == 0 {
+			img.SetNRGBA(x, y, color.NRGBA{})
+			return
+		}
+		img.SetNRGBA(x, y, color.NRGBA{to8(r / a), to8(g / a), to8(b / a), to8(a)})
+		return
+	}
+	img.Set(x, y, color.RGBA64{to16(r), to16(g), to16(b), to16(a)})
+}
+
+func to8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Floor(v*255+0.5))))
+}
+
+func to16(v float64) uint16 {
+	return uint16(math.Max(0, math.Min(0xffff, math.Floor(v*0xffff+0.5))))
+}
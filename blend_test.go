@@ -0,0 +1,133 @@
+package tilemerge
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+var blendModes = []struct {
+	name string
+	mode BlendMode
+}{
+	{"multiply", BlendMultiply},
+	{"screen", BlendScreen},
+	{"overlay", BlendOverlay},
+	{"soft_light", BlendSoftLight},
+}
+
+// shadeSource returns tiles shaded from black in the upper left to white in
+// the lower right, like a hillshade
+type shadeSource struct {
+	tileSize int
+}
+
+func (s shadeSource) GetTile(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+	tile := image.NewNRGBA(image.Rect(0, 0, s.tileSize, s.tileSize))
+	for py := 0; py < s.tileSize; py++ {
+		for px := 0; px < s.tileSize; px++ {
+			v := uint8((px + py) * 255 / (2*s.tileSize - 2))
+			tile.SetNRGBA(px, py, color.NRGBA{v, v, v, 255})
+		}
+	}
+	return *encodePNG(tile), nil
+}
+
+func Test_BlendMode_Draw(t *testing.T) {
+	tests := []struct {
+		mode     BlendMode
+		backdrop color.NRGBA
+		source   color.NRGBA
+		expected color.NRGBA
+	}{
+		{BlendMultiply, color.NRGBA{200, 100, 50, 255}, color.NRGBA{128, 255, 0, 255}, color.NRGBA{100, 100, 0, 255}},
+		{BlendScreen, color.NRGBA{200, 100, 50, 255}, color.NRGBA{128, 255, 0, 255}, color.NRGBA{228, 255, 50, 255}},
+		{BlendOverlay, color.NRGBA{64, 192, 255, 255}, color.NRGBA{128, 128, 128, 255}, color.NRGBA{64, 192, 255, 255}},
+		{BlendOverlay, color.NRGBA{51, 204, 0, 255}, color.NRGBA{255, 51, 128, 255}, color.NRGBA{102, 173, 0, 255}},
+		{BlendSoftLight, color.NRGBA{64, 192, 255, 255}, color.NRGBA{128, 128, 128, 255}, color.NRGBA{64, 192, 255, 255}},
+		{BlendSoftLight, color.NRGBA{51, 204, 51, 255}, color.NRGBA{0, 255, 255, 255}, color.NRGBA{10, 228, 114, 255}},
+		// transparent backdrop shows the source
+		{BlendMultiply, color.NRGBA{}, color.NRGBA{100, 150, 200, 255}, color.NRGBA{100, 150, 200, 255}},
+		// half transparent source over opaque backdrop
+		{BlendMultiply, color.NRGBA{200, 200, 200, 255}, color.NRGBA{0, 0, 0, 128}, color.NRGBA{100, 100, 100, 255}},
+		// opaque source over half transparent backdrop
+		{BlendScreen, color.NRGBA{255, 255, 255, 128}, color.NRGBA{0, 0, 0, 255}, color.NRGBA{128, 128, 128, 255}},
+		// half transparent source over half transparent backdrop
+		{BlendMultiply, color.NRGBA{255, 0, 0, 128}, color.NRGBA{0, 0, 255, 128}, color.NRGBA{85, 0, 85, 192}},
+	}
+
+	newImages := func() []draw.Image {
+		return []draw.Image{image.NewRGBA(image.Rect(0, 0, 1, 1)), image.NewNRGBA(image.Rect(0, 0, 1, 1))}
+	}
+
+	for i, tc := range tests {
+		// every combination of 8-bit RGBA and NRGBA images gives the same result,
+		// apart from rounding of premultiplied colors
+		for _, dst := range newImages() {
+			for _, src := range newImages() {
+				dst.Set(0, 0, tc.backdrop)
+				src.Set(0, 0, tc.source)
+				tc.mode.Draw(dst, dst.Bounds(), src, image.ZP, 1)
+
+				c := color.NRGBAModel.Convert(dst.At(0, 0)).(color.NRGBA)
+				if !nrgbaClose(c, tc.expected, 1) {
+					t.Errorf("case %v: Draw() of %T over %T = %v, expected %v", i, src, dst, c, tc.expected)
+				}
+			}
+		}
+	}
+}
+
+func Test_BlendMode_Draw_Opacity(t *testing.T) {
+	dst := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 255})
+
+	dst.SetNRGBA(0, 0, color.NRGBA{200, 200, 200, 255})
+	BlendMultiply.Draw(dst, dst.Bounds(), src, image.ZP, 0.5)
+	if c := dst.NRGBAAt(0, 0); !nrgbaClose(c, color.NRGBA{100, 100, 100, 255}, 1) {
+		t.Errorf("Draw() with opacity 0.5 = %v, expected %v", c, color.NRGBA{100, 100, 100, 255})
+	}
+
+	dst.SetNRGBA(0, 0, color.NRGBA{200, 200, 200, 255})
+	BlendMultiply.Draw(dst, dst.Bounds(), src, image.ZP, 0)
+	if c := dst.NRGBAAt(0, 0); c != (color.NRGBA{200, 200, 200, 255}) {
+		t.Errorf("Draw() with opacity 0 changed the backdrop to %v", c)
+	}
+}
+
+func Test_MergeLayers_Blend(t *testing.T) {
+	for _, tc := range blendModes {
+		t.Run(tc.name, func(t *testing.T) {
+			shade := NewLayer(shadeSource{TILE_SIZE})
+			shade.Blend = tc.mode
+			layers := []Layer{NewLayer(testTiles("png")), shade}
+
+			img, err := MergeLayers(context.Background(), layers, 4, 3, 5, 0, 0, 512, 512, color.White)
+			if err != nil {
+				panic(err)
+			}
+
+			filename := "test_data/output/test_layers_blend_" + tc.name + ".png"
+			if *update {
+				exportPNG(img, filename)
+			}
+
+			verifyDimensions(t, img, 512, 512)
+			verifyPNG(t, img, filename)
+		})
+	}
+}
+
+func nrgbaClose(a, b color.NRGBA, tolerance int) bool {
+	for _, d := range []int{
+		int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A),
+	} {
+		if d < -tolerance || d > tolerance {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,214 @@
+package tilemerge
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/brendan-ward/tilemerge/mercator"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// View describes a rectangle of pixels in the Web Mercator world at a zoom level.
+// The zoom level may be fractional, in which case tiles are fetched from the
+// nearest integer zoom level and scaled to fit, as Leaflet does, or from the
+// zoom level set by the TileZoom option.
+type View struct {
+	Zoom          float64
+	X, Y          int // pixel coordinates of the upper left corner, from the upper left of the world
+	Width, Height int
+	TileSize      int
+}
+
+// TileRange returns the range of tiles covered by the view, and the offset
+// in pixels of the view from the upper left of tile x0, y0
+func (v View) TileRange() (x0, y0, x1, y1, xOff, yOff int) {
+	x0 = mercator.TileIndex(v.X, v.TileSize)
+	y0 = mercator.TileIndex(v.Y, v.TileSize)
+	x1 = mercator.TileIndex(v.X+v.Width-1, v.TileSize)
+	y1 = mercator.TileIndex(v.Y+v.Height-1, v.TileSize)
+	return x0, y0, x1, y1, v.X - x0*v.TileSize, v.Y - y0*v.TileSize
+}
+
+// BoundsView returns the View covering the bounds west, south, east, north
+// (in degrees) at zoom, for tiles that are tileSize pixels square.
+// Pixel edges are rounded to the nearest pixel.
+// Bounds where east is less than west cross the anti-meridian, and
+// longitudes may extend beyond -180 or 180 to cover more than one world.
+func BoundsView(west, south, east, north float64, zoom uint8, tileSize int) (View, error) {
+	if tileSize <= 0 {
+		return View{}, errors.New("tilemerge: tile size must be known to merge by bounds")
+	}
+	if east < west {
+		// bounds cross the anti-meridian
+		east += 360
+	}
+	if west == east || south >= north {
+		return View{}, errors.New("tilemerge: bounds must have west != east and south < north")
+	}
+
+	x0, y0 := mercator.Project(west, north, float64(zoom), tileSize)
+	x1, y1 := mercator.Project(east, south, float64(zoom), tileSize)
+
+	view := View{
+		Zoom:     float64(zoom),
+		X:        int(math.Floor(x0 + 0.5)),
+		Y:        int(math.Floor(y0 + 0.5)),
+		TileSize: tileSize,
+	}
+	view.Width = int(math.Floor(x1+0.5)) - view.X
+	view.Height = int(math.Floor(y1+0.5)) - view.Y
+
+	if view.Width <= 0 || view.Height <= 0 {
+		return View{}, errors.New("tilemerge: bounds are less than one pixel at zoom")
+	}
+	return view, nil
+}
+
+// MergeView merges the tiles from src covered by view into a single Image
+// with the dimensions of the view.
+// Tile columns are wrapped across the anti-meridian when requested from src,
+// so views wider than the world repeat it horizontally.  Tiles above or
+// below the world are left empty.
+func MergeView(ctx context.Context, src TileSource, view View, bg color.Color, opts ...Option) (image.Image, error) {
+	// tile size of the view takes precedence over any in opts
+	o := newOptions(opts)
+	o.tileSize = view.TileSize
+	src = newWorldSource(src)
+
+	return mergeView(view, o, func(z uint8, x, y, width, height int) (image.Image, error) {
+		return merge(ctx, src, z, x, y, width, height, bg, o)
+	})
+}
+
+// mergeView merges the area of view using mergeFn at the tile zoom level
+// for the zoom level of the view, and scales the result if the zoom level of
+// the view is fractional or the output is scaled by o.  The result is
+// rotated, drawn over and quantized if set by o.
+func mergeView(view View, o *options, mergeFn func(z uint8, x, y, width, height int) (image.Image, error)) (image.Image, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if view.TileSize <= 0 {
+		return nil, errors.New("tilemerge: tile size must be known to merge a view")
+	}
+	if view.Width < 0 || view.Height < 0 {
+		return nil, errors.New("tilemerge: width and height must not be negative")
+	}
+	if view.Zoom < 0 {
+		return nil, errors.New("tilemerge: zoom must not be negative")
+	}
+	tileZoom := o.tileZoom.round(view.Zoom)
+	if tileZoom > math.MaxUint8 {
+		return nil, errors.New("tilemerge: zoom of tiles must not be greater than 255")
+	}
+
+	// scale from pixels at tile zoom to pixels at view zoom
+	zoomScale := math.Exp2(view.Zoom - tileZoom)
+	x, y, width, height := o.scaledArea(view.X, view.Y, view.Width, view.Height)
+	img, err := mergeScaled(zoomScale*o.scale, o.bearing, o.filter, x, y, width, height, func(x, y, width, height int) (image.Image, error) {
+		return mergeFn(uint8(tileZoom), x, y, width, height)
+	})
+	if err != nil {
+		return nil, err
+	}
+	img = o.drawOverlays(img, newGeoreference(view.X, view.Y, view.Width, view.Height, view.Zoom, view.TileSize, o))
+	return o.output(img), nil
+}
+
+// mergeOutput merges the area of width by height pixels with its upper left
+// corner at pixel x, y at zoom z using mergeFn, and scales, rotates, draws
+// overlays on and quantizes the result as set by o
+func mergeOutput(o *options, z uint8, x, y, width, height int, mergeFn func(x, y, width, height int) (image.Image, error)) (image.Image, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if width < 0 || height < 0 {
+		return nil, errors.New("tilemerge: width and height must not be negative")
+	}
+	sx, sy, sw, sh := o.scaledArea(x, y, width, height)
+	img, err := mergeScaled(o.scale, o.bearing, o.filter, sx, sy, sw, sh, mergeFn)
+	if err != nil {
+		return nil, err
+	}
+	img = o.drawOverlays(img, newGeoreference(x, y, width, height, float64(z), o.tileSize, o))
+	return o.output(img), nil
+}
+
+// mergeScaled merges the area of width by height pixels with its upper left
+// corner at x, y, where pixels are scale times the size of those merged by
+// mergeFn.  The area is rotated about its center so that its top faces
+// bearing degrees clockwise from north.  The area merged by mergeFn is
+// resampled with filter to fit.
+func mergeScaled(scale, bearing float64, filter Filter, x, y float64, width, height int, mergeFn func(x, y, width, height int) (image.Image, error)) (image.Image, error) {
+	if scale == 1 && bearing == 0 && x == math.Floor(x) && y == math.Floor(y) {
+		return mergeFn(int(x), int(y), width, height)
+	}
+
+	// The map is rotated counter-clockwise by the bearing, so the corners of
+	// the area come from a larger box around its center.  dx, dy are the
+	// extra pixels on each side of the area covered by the rotated box.
+	sin, cos := math.Sincos(bearing * math.Pi / 180)
+	cx, cy := float64(width)/2, float64(height)/2
+	dx := math.Abs(cos)*cx + math.Abs(sin)*cy - cx
+	dy := math.Abs(sin)*cx + math.Abs(cos)*cy - cy
+
+	// Merge the area covered by the output plus enough pixels on each side
+	// that the filter has neighbors at the edges.
+	margin := int(math.Ceil(filter.support() * math.Max(1, 1/scale)))
+	x0 := int(math.Floor((x-dx)/scale)) - margin
+	y0 := int(math.Floor((y-dy)/scale)) - margin
+	x1 := int(math.Ceil((x+float64(width)+dx)/scale)) + margin
+	y1 := int(math.Ceil((y+float64(height)+dy)/scale)) + margin
+
+	merged, err := mergeFn(x0, y0, x1-x0, y1-y0)
+	if err != nil {
+		return nil, err
+	}
+
+	// merged pixels are scaled and offset into the area, then rotated
+	// counter-clockwise about the center of the output
+	tx, ty := float64(x0)*scale-x, float64(y0)*scale-y
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	s2d := f64.Aff3{
+		cos * scale, sin * scale, cos*tx + sin*ty + cx*(1-cos) - sin*cy,
+		-sin * scale, cos * scale, -sin*tx + cos*ty + cy*(1-cos) + sin*cx,
+	}
+	filter.interpolator().Transform(img, s2d, merged, merged.Bounds(), xdraw.Src, nil)
+
+	return img, nil
+}
+
+// worldSource wraps a TileSource to wrap tile columns across the
+// anti-meridian, and to leave tiles above or below the world empty.
+// merge requests the tile of columns that repeat once, and draws it at each
+// of them, so tiles are not held after they are drawn.
+type worldSource struct {
+	src TileSource
+}
+
+func newWorldSource(src TileSource) *worldSource {
+	return &worldSource{src: src}
+}
+
+func (s *worldSource) GetTile(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+	if y < 0 || y >= 1<<z {
+		return nil, nil
+	}
+	return s.src.GetTile(ctx, z, mercator.WrapX(x, z), y)
+}
+
+// MergeBounds merges the tiles from src covering the bounds west, south,
+// east, north (in degrees) at zoom into a single Image.
+// The tile size must be known in advance; it is TILE_SIZE unless set with
+// the TileSize option.
+func MergeBounds(ctx context.Context, src TileSource, west, south, east, north float64, zoom uint8, bg color.Color, opts ...Option) (image.Image, error) {
+	view, err := BoundsView(west, south, east, north, zoom, newOptions(opts).tileSize)
+	if err != nil {
+		return nil, err
+	}
+	return MergeView(ctx, src, view, bg, opts...)
+}
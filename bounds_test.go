@@ -0,0 +1,241 @@
+package tilemerge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/brendan-ward/tilemerge/mercator"
+)
+
+func Test_BoundsView(t *testing.T) {
+	cases := []struct {
+		west, south, east, north float64
+		zoom                     uint8
+		tileSize                 int
+		view                     View
+	}{
+		{-180, -mercator.MaxLatitude, 180, mercator.MaxLatitude, 1, 256, View{1, 0, 0, 512, 512, 256}},
+		{-180, -mercator.MaxLatitude, 180, mercator.MaxLatitude, 1, 512, View{1, 0, 0, 1024, 1024, 512}},
+		{-180, -90, 180, 90, 0, 256, View{0, 0, 0, 256, 256, 256}},
+		{-180, 0, 0, mercator.MaxLatitude, 1, 256, View{1, 0, 0, 256, 256, 256}},
+		{-90, 0, 90, 45, 2, 256, View{2, 256, 368, 512, 144, 256}},
+		// crosses the anti-meridian
+		{170, -10, -170, 10, 2, 256, View{2, 996, 483, 56, 58, 256}},
+		{170, -10, 190, 10, 2, 256, View{2, 996, 483, 56, 58, 256}},
+		// more than one world wide
+		{-360, -mercator.MaxLatitude, 360, mercator.MaxLatitude, 0, 256, View{0, -128, 0, 512, 256, 256}},
+	}
+
+	for _, c := range cases {
+		view, err := BoundsView(c.west, c.south, c.east, c.north, c.zoom, c.tileSize)
+		if err != nil {
+			t.Errorf("BoundsView(%v, %v, %v, %v, %v, %v) returned error: %v",
+				c.west, c.south, c.east, c.north, c.zoom, c.tileSize, err)
+			continue
+		}
+		if view != c.view {
+			t.Errorf("BoundsView(%v, %v, %v, %v, %v, %v) = %+v, expected %+v",
+				c.west, c.south, c.east, c.north, c.zoom, c.tileSize, view, c.view)
+		}
+	}
+}
+
+func Test_BoundsView_Invalid(t *testing.T) {
+	cases := []struct {
+		west, south, east, north float64
+		zoom                     uint8
+		tileSize                 int
+	}{
+		{10, 0, 10, 10, 1, 256},  // west == east
+		{0, 10, 10, 0, 1, 256},   // south > north
+		{0, 0, 0.1, 0.1, 0, 256}, // less than a pixel
+		{0, 0, 10, 10, 1, AutoTileSize},
+	}
+
+	for _, c := range cases {
+		if _, err := BoundsView(c.west, c.south, c.east, c.north, c.zoom, c.tileSize); err == nil {
+			t.Errorf("BoundsView(%v, %v, %v, %v, %v, %v) did not return an error",
+				c.west, c.south, c.east, c.north, c.zoom, c.tileSize)
+		}
+	}
+}
+
+func Test_View_TileRange(t *testing.T) {
+	view := View{Zoom: 2, X: -100, Y: 300, Width: 400, Height: 300, TileSize: 256}
+	x0, y0, x1, y1, xOff, yOff := view.TileRange()
+	if x0 != -1 || y0 != 1 || x1 != 1 || y1 != 2 || xOff != 156 || yOff != 44 {
+		t.Errorf("TileRange() = %v, %v, %v, %v, %v, %v, expected -1, 1, 1, 2, 156, 44",
+			x0, y0, x1, y1, xOff, yOff)
+	}
+}
+
+// Merging the whole world should produce the same output as merging all tiles
+func Test_MergeBounds_World(t *testing.T) {
+	tiles := jpgTiles()
+	width, height := mergedSize(tiles, TILE_SIZE)
+	expected, err := Merge(tiles, 0, 0, width, height, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	img, err := MergeBounds(context.Background(), testTiles("jpg"), -180, -mercator.MaxLatitude, 180, mercator.MaxLatitude, 1, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	verifyDimensions(t, img, width, height)
+	if !bytes.Equal(img.(*image.RGBA).Pix, expected.(*image.RGBA).Pix) {
+		t.Error("MergeBounds() did not match Merge() of all tiles")
+	}
+}
+
+func Test_MergeBounds(t *testing.T) {
+	img, err := MergeBounds(context.Background(), testTiles("png"), -127, 26, -75, 54, 4, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if *update {
+		exportPNG(img, "test_data/output/test_bounds.png")
+	}
+
+	verifyDimensions(t, img, 592, 426)
+	verifyPNG(t, img, "test_data/output/test_bounds.png")
+}
+
+func Test_MergeBounds_Antimeridian(t *testing.T) {
+	img, err := MergeBounds(context.Background(), testTiles("jpg"), 90, -60, -90, 60, 1, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if *update {
+		exportJPG(img, "test_data/output/test_antimeridian.jpg")
+	}
+
+	verifyDimensions(t, img, 256, 214)
+	verifyJPG(t, img, "test_data/output/test_antimeridian.jpg")
+}
+
+// A view wider than the world repeats it, but only fetches each tile once
+func Test_MergeView_Repeat_World(t *testing.T) {
+	fetched := make(map[string]int)
+	fetch := TileSourceFunc(func(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+		fetched[fmt.Sprintf("%v/%v/%v", z, x, y)]++
+		return testTiles("jpg").GetTile(ctx, z, x, y)
+	})
+
+	view := View{Zoom: 1, X: -300, Y: 0, Width: 1200, Height: 512, TileSize: TILE_SIZE}
+	img, err := MergeView(context.Background(), fetch, view, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if *update {
+		exportJPG(img, "test_data/output/test_repeat_world.jpg")
+	}
+
+	verifyDimensions(t, img, 1200, 512)
+	verifyJPG(t, img, "test_data/output/test_repeat_world.jpg")
+
+	if len(fetched) != 4 {
+		t.Errorf("MergeView() fetched %v distinct tiles, expected 4", len(fetched))
+	}
+	for tile, count := range fetched {
+		if count != 1 {
+			t.Errorf("MergeView() fetched tile %v %v times", tile, count)
+		}
+	}
+}
+
+func Test_Bearing(t *testing.T) {
+	// the rotated box of a 240 x 160 image at the center of the world is
+	// covered by the tiles at zoom 1
+	img, err := MergeSource(context.Background(), testTiles("jpg"), 1, 0, 0, 136, 176, 240, 160, nil, Bearing(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		exportPNG(img, "test_data/output/test_bearing.png")
+	}
+
+	verifyDimensions(t, img, 240, 160)
+	rgba := img.(*image.RGBA)
+	for i := 3; i < len(rgba.Pix); i += 4 {
+		if rgba.Pix[i] != 0xff {
+			t.Fatalf("rotated pixel %v, %v is not opaque", i/4%240, i/4/240)
+		}
+	}
+	verifyPNG(t, img, "test_data/output/test_bearing.png")
+}
+
+func Test_Bearing_Invalid(t *testing.T) {
+	for _, bearing := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		opt := Bearing(bearing)
+		if _, err := MergeSource(context.Background(), testTiles("jpg"), 1, 0, 0, 136, 176, 240, 160, nil, opt); err == nil {
+			t.Errorf("MergeSource() did not return error for bearing %v", bearing)
+		}
+		if _, err := MergeViewport(context.Background(), testTiles("png"), -98.5, 39.8, 4, 320, 240, nil, opt); err == nil {
+			t.Errorf("MergeViewport() did not return error for bearing %v", bearing)
+		}
+		if _, err := TilesGeoreference(jpgTiles(), 0, 0, 100, 100, opt); err == nil {
+			t.Errorf("TilesGeoreference() did not return error for bearing %v", bearing)
+		}
+	}
+}
+
+func Test_Bearing_90(t *testing.T) {
+	src := testTiles("jpg")
+	img, err := MergeSource(context.Background(), src, 1, 0, 0, 136, 176, 240, 160, nil, Bearing(90))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the top of the image faces east, so the image is the unrotated area
+	// with the same center turned counter-clockwise
+	unrotated, err := MergeSource(context.Background(), src, 1, 0, 0, 136+40, 176-40, 160, 240, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rgba, urgba := img.(*image.RGBA), unrotated.(*image.RGBA)
+	max := 0
+	for y := 0; y < 160; y++ {
+		for x := 0; x < 240; x++ {
+			a, b := rgba.RGBAAt(x, y), urgba.RGBAAt(159-y, x)
+			for _, diff := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A)} {
+				if diff < 0 {
+					diff = -diff
+				}
+				if diff > max {
+					max = diff
+				}
+			}
+		}
+	}
+	if max > 1 {
+		t.Errorf("image rotated by 90 degrees differs from unrotated image by %v", max)
+	}
+}
+
+func Test_Bearing_Fetches_Corners(t *testing.T) {
+	var fetched []image.Point
+	fetch := TileSourceFunc(func(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+		fetched = append(fetched, image.Pt(x, y))
+		return nil, nil
+	})
+
+	// a 256 x 256 image that fits in tile 1, 1 rotated by 45 degrees reaches
+	// into each of its neighbors
+	if _, err := MergeSource(context.Background(), fetch, 4, 1, 1, 0, 0, 256, 256, nil, Bearing(45)); err != nil {
+		t.Fatal(err)
+	}
+	if len(fetched) != 9 {
+		t.Errorf("MergeSource() with Bearing(45) fetched %v tiles, expected 9", len(fetched))
+	}
+}
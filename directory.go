@@ -0,0 +1,74 @@
+package tilemerge
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/brendan-ward/tilemerge/mercator"
+)
+
+// DirectorySource is a TileSource that reads tiles from files in a directory.
+//
+// The path of each tile is created from a template relative to the
+// directory, which may contain the following placeholders:
+//
+//	{z}  zoom level
+//	{x}  tile column
+//	{y}  tile row, numbered from the top of the world (XYZ)
+//	{-y} tile row, numbered from the bottom of the world (TMS)
+//	{q}  Bing Maps quadkey
+//
+// For example, "{z}/{x}/{y}.png" or "{z}_{x}_{y}.jpg".
+type DirectorySource struct {
+	Dir      string
+	Template string
+}
+
+// NewDirectorySource returns a DirectorySource for tiles in dir with paths
+// created from template
+func NewDirectorySource(dir, template string) *DirectorySource {
+	return &DirectorySource{Dir: dir, Template: template}
+}
+
+// Path returns the path to the tile at z, x, y
+func (s *DirectorySource) Path(z uint8, x, y int) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(expandTemplate(s.Template, z, x, y)))
+}
+
+// GetTile reads the tile at z, x, y from disk.
+// It returns nil if the tile file does not exist.
+func (s *DirectorySource) GetTile(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(s.Path(z, x, y))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// expandTemplate replaces the tile coordinate placeholders in template
+func expandTemplate(template string, z uint8, x, y int) string {
+	// only calculate a quadkey if it is needed
+	q := ""
+	if strings.Contains(template, "{q}") {
+		q = mercator.Quadkey(z, x, y)
+	}
+
+	return strings.NewReplacer(
+		"{z}", strconv.Itoa(int(z)),
+		"{x}", strconv.Itoa(x),
+		"{y}", strconv.Itoa(y),
+		"{-y}", strconv.Itoa(mercator.FlipY(y, z)),
+		"{q}", q,
+	).Replace(template)
+}
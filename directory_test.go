@@ -0,0 +1,59 @@
+package tilemerge
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func Test_DirectorySource_Path(t *testing.T) {
+	cases := []struct {
+		template string
+		path     string
+	}{
+		{"{z}/{x}/{y}.png", "4/3/5.png"},
+		{"{z}_{x}_{y}.jpg", "4_3_5.jpg"},
+		{"{z}/{x}/{-y}.png", "4/3/10.png"},
+		{"{q}.jpeg", "0213.jpeg"},
+		{"tiles/{z}/{x}/{y}@2x.webp", "tiles/4/3/5@2x.webp"},
+	}
+
+	for _, c := range cases {
+		src := NewDirectorySource("root", c.template)
+		expected := filepath.Join("root", filepath.FromSlash(c.path))
+		if path := src.Path(4, 3, 5); path != expected {
+			t.Errorf("Path() with template %q = %q, expected %q", c.template, path, expected)
+		}
+	}
+}
+
+func Test_DirectorySource_GetTile(t *testing.T) {
+	src := testTiles("png")
+	data, err := src.GetTile(context.Background(), 4, 3, 5)
+	if err != nil {
+		panic(err)
+	}
+
+	if !bytes.Equal(data, *readFile("test_data/4_3_5.png")) {
+		t.Error("GetTile() did not return the tile file")
+	}
+}
+
+func Test_DirectorySource_Missing(t *testing.T) {
+	data, err := testTiles("png").GetTile(context.Background(), 4, 0, 0)
+	if err != nil {
+		t.Errorf("GetTile() for missing tile returned error: %v", err)
+	}
+	if data != nil {
+		t.Error("GetTile() for missing tile returned data")
+	}
+}
+
+func Test_DirectorySource_Error(t *testing.T) {
+	// tile path is a directory
+	src := NewDirectorySource("test_data", "output")
+	if _, err := src.GetTile(context.Background(), 4, 3, 5); err == nil {
+		t.Error("GetTile() for unreadable tile did not return an error")
+	}
+}
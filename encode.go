@@ -0,0 +1,174 @@
+package tilemerge
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/brendan-ward/tilemerge/internal/jpeg"
+)
+
+// Format is an image format that merged images can be encoded to.
+// Names match the format of tiles in MBTiles metadata.
+type Format string
+
+// Supported formats
+const (
+	PNG  Format = "png"
+	JPG  Format = "jpg"
+	WEBP Format = "webp"
+	GIF  Format = "gif"
+)
+
+// ChromaSubsampling is the resolution of color relative to brightness in
+// JPEG images
+type ChromaSubsampling int
+
+const (
+	// Subsample420 stores color at half the width and height of the image;
+	// this is smaller, but blurs the edges of thin colored features
+	Subsample420 ChromaSubsampling = iota
+	// Subsample444 stores color at the full resolution of the image
+	Subsample444
+)
+
+// EncodeOption sets optional behavior of Encode
+type EncodeOption func(*encodeOptions)
+
+type encodeOptions struct {
+	jpegQuality     int
+	jpegSubsampling ChromaSubsampling
+	pngCompression  png.CompressionLevel
+	webpLossless    bool
+	webpQuality     int
+	gifPalette      color.Palette
+
+	tiffUncompressed bool
+}
+
+func newEncodeOptions(opts []EncodeOption) *encodeOptions {
+	o := &encodeOptions{
+		jpegQuality: jpeg.DefaultQuality,
+		webpQuality: webpDefaultQuality,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// JPEGQuality sets the quality of JPEG images, from 1 to 100; higher is
+// better.  The default is 75.
+func JPEGQuality(quality int) EncodeOption {
+	return func(o *encodeOptions) {
+		o.jpegQuality = quality
+	}
+}
+
+// JPEGSubsampling sets the chroma subsampling of JPEG images.
+// The default is Subsample420.
+func JPEGSubsampling(s ChromaSubsampling) EncodeOption {
+	return func(o *encodeOptions) {
+		o.jpegSubsampling = s
+	}
+}
+
+// PNGCompression sets the compression level of PNG images
+func PNGCompression(level png.CompressionLevel) EncodeOption {
+	return func(o *encodeOptions) {
+		o.pngCompression = level
+	}
+}
+
+// WebPLossless sets whether WebP images are compressed losslessly.
+// WebP images are lossy by default.
+func WebPLossless(lossless bool) EncodeOption {
+	return func(o *encodeOptions) {
+		o.webpLossless = lossless
+	}
+}
+
+// webpDefaultQuality is the default quality of lossy WebP images
+const webpDefaultQuality = 75
+
+// WebPQuality sets the quality of lossy WebP images, from 1 to 100; higher
+// is better.  The default is 75.
+func WebPQuality(quality int) EncodeOption {
+	return func(o *encodeOptions) {
+		o.webpQuality = quality
+	}
+}
+
+// WebPEncoder writes img to w as WebP, compressed losslessly or at quality
+// from 1 to 100
+type WebPEncoder func(w io.Writer, img image.Image, lossless bool, quality int) error
+
+var webpEncoder WebPEncoder
+
+// RegisterWebPEncoder sets the encoder that Encode uses for WEBP.  Encoding
+// WebP needs cgo, so no encoder is built into this package; importing
+// github.com/brendan-ward/tilemerge/webp registers one backed by libwebp.
+// RegisterWebPEncoder is meant to be called from init functions.
+func RegisterWebPEncoder(enc WebPEncoder) {
+	webpEncoder = enc
+}
+
+// GIFPalette sets the palette of GIF images, of up to 256 colors.
+// Images are dithered to the palette with Floyd-Steinberg error diffusion.
+// By default, paletted images keep their palette, and other images are
+// dithered to the Plan 9 palette.
+func GIFPalette(p color.Palette) EncodeOption {
+	return func(o *encodeOptions) {
+		o.gifPalette = p
+	}
+}
+
+// Encode writes img to w in format.  "jpeg" is accepted as well as JPG,
+// and format names are not case sensitive.  Options for other formats than
+// format are ignored.
+// WEBP returns an error unless a WebP encoder is registered, see
+// RegisterWebPEncoder.
+func Encode(w io.Writer, img image.Image, format Format, opts ...EncodeOption) error {
+	o := newEncodeOptions(opts)
+
+	switch Format(strings.ToLower(string(format))) {
+	case PNG:
+		enc := png.Encoder{CompressionLevel: o.pngCompression}
+		return enc.Encode(w, img)
+
+	case JPG, "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{
+			Quality:     o.jpegQuality,
+			Subsampling: jpeg.Subsampling(o.jpegSubsampling),
+		})
+
+	case WEBP:
+		if webpEncoder == nil {
+			return errors.New("tilemerge: no WebP encoder is registered, import github.com/brendan-ward/tilemerge/webp")
+		}
+		if img.Bounds().Empty() {
+			return errors.New("tilemerge: cannot encode an empty image as WebP")
+		}
+		return webpEncoder(w, img, o.webpLossless, o.webpQuality)
+
+	case GIF:
+		if o.gifPalette == nil {
+			return gif.Encode(w, img, nil)
+		}
+		if len(o.gifPalette) > 256 {
+			return errors.New("tilemerge: GIF palette must have at most 256 colors")
+		}
+		b := img.Bounds()
+		paletted := image.NewPaletted(b, o.gifPalette)
+		draw.FloydSteinberg.Draw(paletted, b, img, b.Min)
+		return gif.Encode(w, paletted, nil)
+	}
+
+	return fmt.Errorf("tilemerge: unsupported format %q", format)
+}
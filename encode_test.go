@@ -0,0 +1,173 @@
+package tilemerge
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/png"
+	"io"
+	"testing"
+
+	_ "image/gif" // load gif decoder
+)
+
+func Test_Encode(t *testing.T) {
+	img, err := Merge(jpgTiles(), 0, 0, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		format   Format
+		expected string // format name returned by image.Decode
+	}{
+		{PNG, "png"},
+		{JPG, "jpeg"},
+		{"jpeg", "jpeg"},
+		{"JPG", "jpeg"},
+		{GIF, "gif"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, c.format); err != nil {
+			t.Errorf("Encode() to %v returned error: %v", c.format, err)
+			continue
+		}
+
+		decoded, name, err := image.Decode(&buf)
+		if err != nil {
+			t.Errorf("decoding image encoded to %v returned error: %v", c.format, err)
+			continue
+		}
+		if name != c.expected {
+			t.Errorf("image encoded to %v decoded as %v, expected %v", c.format, name, c.expected)
+		}
+		verifyDimensions(t, decoded, 300, 200)
+	}
+}
+
+func Test_Encode_UnsupportedFormat(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, "pbf"); err == nil {
+		t.Error("Encode() did not return error for unsupported format")
+	}
+}
+
+func Test_Encode_JPEG(t *testing.T) {
+	img, err := Merge(jpgTiles(), 0, 0, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var low, high bytes.Buffer
+	if err := Encode(&low, img, JPG, JPEGQuality(20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encode(&high, img, JPG, JPEGQuality(95), JPEGSubsampling(Subsample444)); err != nil {
+		t.Fatal(err)
+	}
+	if low.Len() >= high.Len() {
+		t.Errorf("JPEG at quality 20 is %v bytes, expected less than %v at quality 95", low.Len(), high.Len())
+	}
+
+	decoded, _, err := image.Decode(&high)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ycbcr, ok := decoded.(*image.YCbCr)
+	if !ok || ycbcr.SubsampleRatio != image.YCbCrSubsampleRatio444 {
+		t.Errorf("JPEG with Subsample444 was not decoded as 4:4:4 YCbCr")
+	}
+}
+
+func Test_Encode_PNG(t *testing.T) {
+	img, err := Merge(jpgTiles(), 0, 0, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var none, best bytes.Buffer
+	if err := Encode(&none, img, PNG, PNGCompression(png.NoCompression)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encode(&best, img, PNG, PNGCompression(png.BestCompression)); err != nil {
+		t.Fatal(err)
+	}
+	if best.Len() >= none.Len() {
+		t.Errorf("PNG with best compression is %v bytes, expected less than %v with no compression", best.Len(), none.Len())
+	}
+}
+
+func Test_Encode_WebP(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+
+	// WebP is encoded by the encoder registered by the webp package
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, WEBP); err == nil {
+		t.Error("Encode() did not return error for WebP without a registered encoder")
+	}
+
+	var lossless bool
+	var quality int
+	RegisterWebPEncoder(func(w io.Writer, img image.Image, l bool, q int) error {
+		lossless, quality = l, q
+		return nil
+	})
+	defer RegisterWebPEncoder(nil)
+
+	if err := Encode(&buf, img, WEBP); err != nil {
+		t.Fatal(err)
+	}
+	if lossless || quality != webpDefaultQuality {
+		t.Errorf("WebP encoder was called with lossless %v, quality %v, expected false, %v", lossless, quality, webpDefaultQuality)
+	}
+	if err := Encode(&buf, img, WEBP, WebPLossless(true), WebPQuality(90)); err != nil {
+		t.Fatal(err)
+	}
+	if !lossless || quality != 90 {
+		t.Errorf("WebP encoder was called with lossless %v, quality %v, expected true, 90", lossless, quality)
+	}
+}
+
+func Test_Encode_GIF(t *testing.T) {
+	img, err := Merge(jpgTiles(), 0, 0, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := color.Palette{
+		color.RGBA{0, 0, 0, 255},
+		color.RGBA{255, 255, 255, 255},
+		color.RGBA{170, 211, 223, 255},
+		color.RGBA{242, 239, 233, 255},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, GIF, GIFPalette(p)); err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := image.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paletted, ok := decoded.(*image.Paletted)
+	if !ok {
+		t.Fatalf("GIF was decoded as %T, expected *image.Paletted", decoded)
+	}
+	if len(paletted.Palette) != len(p) {
+		t.Errorf("GIF has %v colors, expected %v", len(paletted.Palette), len(p))
+	}
+	for i, c := range p {
+		if paletted.Palette[i] != c {
+			t.Errorf("GIF color %v is %v, expected %v", i, paletted.Palette[i], c)
+		}
+	}
+
+	tooMany := append(color.Palette{color.Black}, palette.WebSafe...)
+	tooMany = append(tooMany, palette.Plan9...)
+	if err := Encode(&buf, img, GIF, GIFPalette(tooMany)); err == nil {
+		t.Error("Encode() did not return error for palette with more than 256 colors")
+	}
+}
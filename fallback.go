@@ -0,0 +1,142 @@
+package tilemerge
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// fallbackTile returns an image to draw in place of the tile at z, x, y that
+// src does not have, or nil if the options do not allow a fallback or none
+// is available.  Ancestors are decoded once into parents.
+func fallbackTile(ctx context.Context, src TileSource, parents *ancestors, z uint8, x, y int, o *options) (image.Image, error) {
+	// children have more detail than ancestors, so are tried first
+	if o.underzoom && z < math.MaxUint8 {
+		img, err := underzoomTile(ctx, src, z, x, y, o)
+		if err != nil || img != nil {
+			return img, err
+		}
+	}
+	return overzoomTile(ctx, src, parents, z, x, y, o)
+}
+
+// overzoomTile returns the tile at z, x, y scaled up from the part of the
+// nearest ancestor tile that covers it, up to o.overzoom levels above z.
+// Returns nil if there is no ancestor within that many levels.
+func overzoomTile(ctx context.Context, src TileSource, parents *ancestors, z uint8, x, y int, o *options) (image.Image, error) {
+	tileSize := o.tileSize
+
+	// stop before the part of the ancestor would be less than a pixel
+	for dz := 1; dz <= o.overzoom && dz <= int(z) && tileSize>>uint(dz) > 0; dz++ {
+		pz := z - uint8(dz)
+		px := x >> uint(dz)
+		py := y >> uint(dz)
+
+		parent, err := parents.get(ctx, src, pz, px, py, tileSize)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			continue
+		}
+
+		// part of the parent covered by the tile, with edges rounded to the
+		// nearest pixel for tile sizes that are not a power of 2
+		edge := func(k int) int {
+			return int(math.Floor(float64(k*tileSize)/float64(int(1)<<uint(dz)) + 0.5))
+		}
+		kx := x - px<<uint(dz)
+		ky := y - py<<uint(dz)
+		sr := image.Rect(edge(kx), edge(ky), edge(kx+1), edge(ky+1)).Add(parent.Bounds().Min)
+
+		img := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+		xdraw.BiLinear.Scale(img, img.Bounds(), parent, sr, xdraw.Src, nil)
+		return img, nil
+	}
+	return nil, nil
+}
+
+// ancestors holds the ancestor tiles decoded while merging, since each is
+// shared by up to 4^levels missing tiles that it fills.  It is safe for
+// concurrent use.
+type ancestors struct {
+	mu    sync.Mutex
+	tiles map[tileKey]*decodedTile
+}
+
+type decodedTile struct {
+	once sync.Once
+	img  image.Image
+	err  error
+}
+
+// get returns the tile at z, x, y from src, decoded and checked to be
+// tileSize pixels square, or nil if src does not have it.  Each tile is
+// requested and decoded once.
+func (a *ancestors) get(ctx context.Context, src TileSource, z uint8, x, y int, tileSize int) (image.Image, error) {
+	key := tileKey{z, x, y}
+	a.mu.Lock()
+	if a.tiles == nil {
+		a.tiles = make(map[tileKey]*decodedTile)
+	}
+	tile, ok := a.tiles[key]
+	if !ok {
+		tile = &decodedTile{}
+		a.tiles[key] = tile
+	}
+	a.mu.Unlock()
+
+	tile.once.Do(func() {
+		data, err := src.GetTile(ctx, z, x, y)
+		if err != nil || data == nil {
+			tile.err = err
+			return
+		}
+		tile.img, tile.err = decodeTile(data, z, x, y, tileSize)
+	})
+	return tile.img, tile.err
+}
+
+// underzoomTile returns the tile at z, x, y scaled down from its four child
+// tiles at z+1.  Children that src does not have are left empty.
+// Returns nil if src has none of the children.
+func underzoomTile(ctx context.Context, src TileSource, z uint8, x, y int, o *options) (image.Image, error) {
+	tileSize := o.tileSize
+	var children *image.RGBA
+
+	for dy := 0; dy < 2; dy++ {
+		for dx := 0; dx < 2; dx++ {
+			cx := 2*x + dx
+			cy := 2*y + dy
+			data, err := src.GetTile(ctx, z+1, cx, cy)
+			if err != nil {
+				return nil, err
+			}
+			if data == nil {
+				continue
+			}
+
+			child, err := decodeTile(data, z+1, cx, cy, tileSize)
+			if err != nil {
+				return nil, err
+			}
+
+			if children == nil {
+				children = image.NewRGBA(image.Rect(0, 0, 2*tileSize, 2*tileSize))
+			}
+			r := image.Rect(dx*tileSize, dy*tileSize, (dx+1)*tileSize, (dy+1)*tileSize)
+			draw.Draw(children, r, child, child.Bounds().Min, draw.Src)
+		}
+	}
+	if children == nil {
+		return nil, nil
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	o.underzoomFilter.interpolator().Scale(img, img.Bounds(), children, children.Bounds(), xdraw.Src, nil)
+	return img, nil
+}
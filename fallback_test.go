@@ -0,0 +1,180 @@
+package tilemerge
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+func Test_Overzoom(t *testing.T) {
+	// test_data has png tiles at zoom 4, covering tiles 8 to 19, 20 to 27 at zoom 6
+	img, err := MergeSource(context.Background(), testTiles("png"), 6, 8, 20, 100, 200, 800, 600, nil, Overzoom(2))
+	if err != nil {
+		panic(err)
+	}
+
+	if *update {
+		exportPNG(img, "test_data/output/test_overzoom.png")
+	}
+
+	verifyDimensions(t, img, 800, 600)
+	verifyPNG(t, img, "test_data/output/test_overzoom.png")
+
+	// overzoomed tiles should line up with the area scaled up from zoom 4
+	o := newOptions(nil)
+	expected, err := mergeScaled(4, 0, Bilinear, 8*TILE_SIZE+100, 20*TILE_SIZE+200, 800, 600, func(x, y, width, height int) (image.Image, error) {
+		return merge(context.Background(), testTiles("png"), 4, x, y, width, height, nil, o)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// tiles are scaled individually, so differ slightly at their edges
+	if diff := meanDifference(img, expected); diff > 1 {
+		t.Errorf("Overzoom tiles did not line up with tiles from zoom 4: mean difference %v", diff)
+	}
+}
+
+func Test_Overzoom_MaxLevels(t *testing.T) {
+	// ancestors at zoom 4 are more than 1 level above
+	src := &countingSource{src: testTiles("png")}
+	img, err := MergeSource(context.Background(), src, 6, 12, 20, 0, 0, 256, 256, nil, Overzoom(1))
+	if err != nil {
+		panic(err)
+	}
+
+	for _, v := range img.(*image.RGBA).Pix {
+		if v != 0 {
+			t.Error("Overzoom(1) filled a tile from 2 levels above")
+			break
+		}
+	}
+
+	expected := []string{"6/12/20", "5/6/10"}
+	if !reflect.DeepEqual(src.requested, expected) {
+		t.Errorf("Overzoom(1) requested %v, expected %v", src.requested, expected)
+	}
+}
+
+func Test_Overzoom_Ancestors(t *testing.T) {
+	// the 16 tiles at zoom 6 all fill from tile 4/2/5, which is requested once
+	src := &countingSource{src: testTiles("png")}
+	o := newOptions([]Option{Overzoom(2)})
+	if _, err := merge(context.Background(), src, 6, 8*TILE_SIZE, 20*TILE_SIZE, 4*TILE_SIZE, 4*TILE_SIZE, nil, o); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for _, key := range src.requested {
+		counts[key]++
+	}
+	if counts["4/2/5"] != 1 {
+		t.Errorf("Overzoom requested ancestor 4/2/5 %v times, expected 1", counts["4/2/5"])
+	}
+	for key, count := range counts {
+		if count != 1 {
+			t.Errorf("Overzoom requested %v %v times, expected 1", key, count)
+		}
+	}
+}
+
+func Test_Overzoom_TileSize(t *testing.T) {
+	// the last of 8 columns of tiles below a 300 pixel tile covers pixels
+	// 262.5 to 300 of it, which are white
+	parent := image.NewGray(image.Rect(0, 0, 300, 300))
+	for i := range parent.Pix {
+		if i%300 >= 262 {
+			parent.Pix[i] = 0xff
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, parent); err != nil {
+		t.Fatal(err)
+	}
+	src := TileSourceFunc(func(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+		if z == 0 {
+			return buf.Bytes(), nil
+		}
+		return nil, nil
+	})
+
+	o := newOptions([]Option{TileSize(300), Overzoom(3)})
+	img, err := overzoomTile(context.Background(), src, &ancestors{}, 3, 7, 0, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img == nil {
+		t.Fatal("overzoomTile() did not return a tile")
+	}
+	for i, v := range img.(*image.RGBA).Pix {
+		if v != 0xff {
+			t.Fatalf("overzoomed pixel %v, %v is %v, expected 255", i/4%300, i/4/300, v)
+		}
+	}
+}
+
+func Test_Underzoom(t *testing.T) {
+	// test_data has png tiles at zoom 4, which are children of tiles 1 to 2, 2 to 3 at zoom 3
+	img, err := MergeSource(context.Background(), testTiles("png"), 3, 1, 2, 0, 0, 512, 512, nil, Underzoom(Box))
+	if err != nil {
+		panic(err)
+	}
+
+	verifyDimensions(t, img, 512, 512)
+
+	// a box filter averages each 2x2 block of pixels at zoom 4
+	children, err := MergeSource(context.Background(), testTiles("png"), 4, 2, 4, 0, 0, 1024, 1024, nil)
+	if err != nil {
+		panic(err)
+	}
+	pix := children.(*image.RGBA)
+	expected := image.NewRGBA(image.Rect(0, 0, 512, 512))
+	for i := range expected.Pix {
+		x := (i % expected.Stride) / 4
+		y := i / expected.Stride
+		c := i % 4
+		sum := 0
+		for _, p := range []int{
+			pix.PixOffset(2*x, 2*y), pix.PixOffset(2*x+1, 2*y),
+			pix.PixOffset(2*x, 2*y+1), pix.PixOffset(2*x+1, 2*y+1),
+		} {
+			sum += int(pix.Pix[p+c])
+		}
+		expected.Pix[i] = uint8((sum + 2) / 4)
+	}
+
+	if diff := maxDifference(img, expected); diff > 1 {
+		t.Errorf("Underzoom(Box) did not average child tiles: max difference %v", diff)
+	}
+}
+
+func Test_Underzoom_Lanczos(t *testing.T) {
+	img, err := MergeSource(context.Background(), testTiles("png"), 3, 1, 2, 0, 0, 512, 512, nil, Underzoom(Lanczos))
+	if err != nil {
+		panic(err)
+	}
+
+	if *update {
+		exportPNG(img, "test_data/output/test_underzoom_lanczos.png")
+	}
+
+	verifyDimensions(t, img, 512, 512)
+	verifyPNG(t, img, "test_data/output/test_underzoom_lanczos.png")
+}
+
+// Children are preferred to ancestors when both are allowed
+func Test_Underzoom_Overzoom(t *testing.T) {
+	src := &countingSource{src: testTiles("png")}
+	_, err := MergeSource(context.Background(), src, 5, 4, 10, 0, 0, 256, 256, nil, Underzoom(Box), Overzoom(1))
+	if err != nil {
+		panic(err)
+	}
+
+	expected := []string{"5/4/10", "6/8/20", "6/9/20", "6/8/21", "6/9/21", "4/2/5"}
+	if !reflect.DeepEqual(src.requested, expected) {
+		t.Errorf("requested %v, expected %v", src.requested, expected)
+	}
+}
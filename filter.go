@@ -0,0 +1,122 @@
+package tilemerge
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Filter is a resampling filter used to scale tiles
+type Filter int
+
+const (
+	// Box averages the pixels covered by each output pixel; it is fast and
+	// exact when scaling down by whole numbers
+	Box Filter = iota
+	// Lanczos uses a 3-lobed Lanczos kernel; it is slower than Box but
+	// keeps more detail
+	Lanczos
+	// NearestNeighbor uses the nearest pixel, keeping hard edges
+	NearestNeighbor
+	// Bilinear interpolates linearly between the nearest 2x2 pixels
+	Bilinear
+	// CatmullRom uses a cubic Catmull-Rom kernel over the nearest 4x4
+	// pixels; it is sharper than Bilinear
+	CatmullRom
+)
+
+var (
+	boxKernel     = &xdraw.Kernel{Support: 0.5, At: func(t float64) float64 { return 1 }}
+	lanczosKernel = &xdraw.Kernel{Support: 3, At: lanczos3}
+)
+
+// interpolator returns the interpolator used to resample images with f
+func (f Filter) interpolator() xdraw.Interpolator {
+	switch f {
+	case Lanczos:
+		return lanczosKernel
+	case NearestNeighbor:
+		return xdraw.NearestNeighbor
+	case Bilinear:
+		return xdraw.BiLinear
+	case CatmullRom:
+		return xdraw.CatmullRom
+	}
+	return boxKernel
+}
+
+// kernel returns the kernel of f, or nil for NearestNeighbor
+func (f Filter) kernel() *xdraw.Kernel {
+	k, _ := f.interpolator().(*xdraw.Kernel)
+	return k
+}
+
+// support returns the distance in pixels from the center of an output
+// pixel to the farthest input pixel used by f, when not scaling down
+func (f Filter) support() float64 {
+	if k := f.kernel(); k != nil {
+		return k.Support
+	}
+	return 0.5
+}
+
+// sample returns the color of img at x, y, where pixel centers are at half
+// pixels, resampled with the kernel of f.  Box samples the nearest pixel, as
+// NearestNeighbor does.  Pixels outside img do not contribute, so x, y
+// farther than the kernel from img are transparent.
+func (f Filter) sample(img *image.RGBA, x, y float64) color.RGBA {
+	b := img.Bounds()
+	k := f.kernel()
+	if f == Box || k == nil {
+		p := image.Pt(int(math.Floor(x)), int(math.Floor(y)))
+		if !p.In(b) {
+			return color.RGBA{}
+		}
+		return img.RGBAAt(p.X, p.Y)
+	}
+
+	x, y = x-0.5, y-0.5
+	var sum [4]float64
+	var weights float64
+	for py := int(math.Ceil(y - k.Support)); py <= int(math.Floor(y+k.Support)); py++ {
+		wy := k.At(math.Abs(float64(py) - y))
+		if wy == 0 || py < b.Min.Y || py >= b.Max.Y {
+			continue
+		}
+		for px := int(math.Ceil(x - k.Support)); px <= int(math.Floor(x+k.Support)); px++ {
+			wx := k.At(math.Abs(float64(px) - x))
+			if wx == 0 || px < b.Min.X || px >= b.Max.X {
+				continue
+			}
+			p := img.Pix[img.PixOffset(px, py):]
+			for i := range sum {
+				sum[i] += wx * wy * float64(p[i])
+			}
+			weights += wx * wy
+		}
+	}
+	if weights == 0 {
+		return color.RGBA{}
+	}
+
+	// kernels with negative lobes can overshoot, but premultiplied colors
+	// must not exceed alpha
+	n := 255 * weights
+	a := to8(sum[3] / n)
+	c := func(v float64) uint8 {
+		if v := to8(v / n); v < a {
+			return v
+		}
+		return a
+	}
+	return color.RGBA{c(sum[0]), c(sum[1]), c(sum[2]), a}
+}
+
+func lanczos3(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	return 3 * math.Sin(math.Pi*t) * math.Sin(math.Pi*t/3) / (math.Pi * math.Pi * t * t)
+}
@@ -0,0 +1,105 @@
+package tilemerge
+
+import (
+	"math"
+
+	"github.com/brendan-ward/tilemerge/mercator"
+)
+
+// Georeference locates the pixels of a merged image in Web Mercator
+// (EPSG:3857) coordinates, for GIS tools that need to know where the image is
+type Georeference struct {
+	// X, Y are the coordinates in meters of the upper left corner of the
+	// upper left pixel of the image
+	X, Y float64
+	// PixelSize is the width and height of each pixel in meters
+	PixelSize float64
+	// Bearing is the direction in degrees clockwise from north that the top
+	// of the image faces, if it was rotated with the Bearing option
+	Bearing float64
+}
+
+// newGeoreference returns the Georeference of the image of width by height
+// pixels with its upper left corner at pixel x, y from the upper left of the
+// world at zoom, for tiles that are tileSize pixels square, as merged with o
+func newGeoreference(x, y, width, height int, zoom float64, tileSize int, o *options) Georeference {
+	sx, sy, sw, sh := o.scaledArea(x, y, width, height)
+	zoom += math.Log2(o.scale)
+
+	// the upper left corner of a rotated image is rotated about its center
+	sin, cos := math.Sincos(o.bearing * math.Pi / 180)
+	cx, cy := float64(sw)/2, float64(sh)/2
+	sx += cx - cos*cx + sin*cy
+	sy += cy - sin*cx - cos*cy
+
+	mx, my := mercator.Meters(sx, sy, zoom, tileSize)
+	return Georeference{
+		X:         mx,
+		Y:         my,
+		PixelSize: 2 * mercator.HalfWorld / mercator.WorldSize(zoom, tileSize),
+		Bearing:   o.bearing,
+	}
+}
+
+// TilesGeoreference returns the Georeference of the image merged by Merge
+// from tiles with the same offsets, dimensions and options.
+// If the tile size is set to AutoTileSize, it is detected from tiles as Merge
+// does.
+func TilesGeoreference(tiles Tiles, xOff, yOff, width, height int, opts ...Option) (Georeference, error) {
+	o := newOptions(opts)
+	if err := o.validate(); err != nil {
+		return Georeference{}, err
+	}
+
+	var z uint8
+	if len(tiles.Tiles) > 0 {
+		z = tiles.Tiles[0].Z
+	}
+
+	if o.tileSize == AutoTileSize {
+		size, err := detectTileSize(tiles)
+		if err != nil {
+			return Georeference{}, err
+		}
+		o.tileSize = size
+	}
+
+	return newGeoreference(tiles.X0*o.tileSize+xOff, tiles.Y0*o.tileSize+yOff, width, height, float64(z), o.tileSize, o), nil
+}
+
+// ViewGeoreference returns the Georeference of the image merged by MergeView
+// for view, with the same options
+func ViewGeoreference(view View, opts ...Option) Georeference {
+	return newGeoreference(view.X, view.Y, view.Width, view.Height, view.Zoom, view.TileSize, newOptions(opts))
+}
+
+// transform returns the affine transform from pixels of the image to Web
+// Mercator meters, in the order of a GDAL GeoTransform
+func (g Georeference) transform() [6]float64 {
+	if g.Bearing == 0 {
+		return [6]float64{g.X, g.PixelSize, 0, g.Y, 0, -g.PixelSize}
+	}
+
+	// moving right or down the image moves through the world rotated
+	// clockwise by the bearing
+	sin, cos := math.Sincos(g.Bearing * math.Pi / 180)
+	return [6]float64{
+		g.X, cos * g.PixelSize, -sin * g.PixelSize,
+		g.Y, -sin * g.PixelSize, -cos * g.PixelSize,
+	}
+}
+
+// meters converts the pixel coordinates x, y of the image into Web Mercator
+// meters
+func (g Georeference) meters(x, y float64) (mx, my float64) {
+	t := g.transform()
+	return t[0] + x*t[1] + y*t[2], t[3] + x*t[4] + y*t[5]
+}
+
+// pixel converts Web Mercator meters into pixel coordinates of the image
+func (g Georeference) pixel(mx, my float64) (x, y float64) {
+	// the inverse of a rotation is its transpose
+	sin, cos := math.Sincos(g.Bearing * math.Pi / 180)
+	dx, dy := (mx-g.X)/g.PixelSize, (g.Y-my)/g.PixelSize
+	return cos*dx + sin*dy, -sin*dx + cos*dy
+}
@@ -0,0 +1,110 @@
+package tilemerge
+
+import (
+	"math"
+	"testing"
+
+	"github.com/brendan-ward/tilemerge/mercator"
+)
+
+func georeferenceClose(a, b Georeference) bool {
+	return math.Abs(a.X-b.X) < 1e-6 && math.Abs(a.Y-b.Y) < 1e-6 && math.Abs(a.PixelSize-b.PixelSize) < 1e-9
+}
+
+func Test_TilesGeoreference(t *testing.T) {
+	// zoom 1 is 512 pixels wide with 256 pixel tiles
+	res := 2 * mercator.HalfWorld / 512
+
+	cases := []struct {
+		name       string
+		tiles      Tiles
+		xOff, yOff int
+		opts       []Option
+		expected   Georeference
+	}{
+		{"upper left", jpgTiles(), 0, 0, nil,
+			Georeference{-mercator.HalfWorld, mercator.HalfWorld, res, 0}},
+		{"offset", jpgTiles(), 100, 50, nil,
+			Georeference{-mercator.HalfWorld + 100*res, mercator.HalfWorld - 50*res, res, 0}},
+		{"tile range", loadTiles(1, 1, 1, 1, 1, "jpg"), 10, -20, nil,
+			Georeference{10 * res, 20 * res, res, 0}},
+		{"output scale", jpgTiles(), 100, 50, []Option{OutputScale(2, Bilinear)},
+			Georeference{-mercator.HalfWorld + 100*res, mercator.HalfWorld - 50*res, res / 2, 0}},
+		{"tile size", jpgTiles(), 0, 0, []Option{TileSize(512)},
+			Georeference{-mercator.HalfWorld, mercator.HalfWorld, res / 2, 0}},
+		{"auto tile size", jpgTiles(), 0, 0, []Option{TileSize(AutoTileSize)},
+			Georeference{-mercator.HalfWorld, mercator.HalfWorld, res, 0}},
+	}
+	for _, c := range cases {
+		geo, err := TilesGeoreference(c.tiles, c.xOff, c.yOff, 300, 200, c.opts...)
+		if err != nil {
+			t.Fatalf("%v: TilesGeoreference() returned error: %v", c.name, err)
+		}
+		if !georeferenceClose(geo, c.expected) {
+			t.Errorf("%v: TilesGeoreference() = %+v, expected %+v", c.name, geo, c.expected)
+		}
+	}
+}
+
+func Test_TilesGeoreference_Bearing(t *testing.T) {
+	tiles := jpgTiles()
+	unrotated, err := TilesGeoreference(tiles, 100, 50, 300, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bearing := range []float64{30, 90, -45, 180} {
+		geo, err := TilesGeoreference(tiles, 100, 50, 300, 200, Bearing(bearing))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if geo.Bearing != bearing || geo.PixelSize != unrotated.PixelSize {
+			t.Errorf("TilesGeoreference() with Bearing(%v) = %+v", bearing, geo)
+		}
+
+		// the image is rotated about its center
+		x, y := geo.meters(150, 100)
+		ux, uy := unrotated.meters(150, 100)
+		if math.Abs(x-ux) > 1e-6 || math.Abs(y-uy) > 1e-6 {
+			t.Errorf("Bearing(%v): center is at %v, %v, expected %v, %v", bearing, x, y, ux, uy)
+		}
+
+		// the top of the image faces the bearing
+		x, y = geo.meters(150, 0)
+		if direction := math.Atan2(x-ux, y-uy) * 180 / math.Pi; math.Abs(math.Remainder(direction-bearing, 360)) > 1e-6 {
+			t.Errorf("Bearing(%v): top of image faces %v", bearing, direction)
+		}
+
+		if px, py := geo.pixel(geo.meters(12, 34)); math.Abs(px-12) > 1e-6 || math.Abs(py-34) > 1e-6 {
+			t.Errorf("Bearing(%v): pixel(meters(12, 34)) = %v, %v", bearing, px, py)
+		}
+	}
+}
+
+func Test_ViewGeoreference(t *testing.T) {
+	view, err := BoundsView(-127, 26, -75, 54, 4, TILE_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x0, y0, _, _, xOff, yOff := view.TileRange()
+	tiles := Tiles{
+		Tiles: []Tile{{Z: 4, X: x0, Y: y0}},
+		X0:    x0, Y0: y0,
+	}
+
+	expected, err := TilesGeoreference(tiles, xOff, yOff, view.Width, view.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if geo := ViewGeoreference(view); !georeferenceClose(geo, expected) {
+		t.Errorf("ViewGeoreference() = %+v, expected %+v", geo, expected)
+	}
+
+	// the west and north edges of the view are within half a pixel of the bounds
+	geo := ViewGeoreference(view)
+	x, y := mercator.Project(-127, 54, 4, TILE_SIZE)
+	west, north := mercator.Meters(x, y, 4, TILE_SIZE)
+	if math.Abs(geo.X-west) > geo.PixelSize/2 || math.Abs(geo.Y-north) > geo.PixelSize/2 {
+		t.Errorf("ViewGeoreference() = %+v, expected upper left near %v, %v", geo, west, north)
+	}
+}
@@ -0,0 +1,281 @@
+package tilemerge
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// TIFF tags, from the TIFF 6.0 and GeoTIFF 1.1 specifications
+const (
+	tagImageWidth                = 256
+	tagImageLength               = 257
+	tagBitsPerSample             = 258
+	tagCompression               = 259
+	tagPhotometricInterpretation = 262
+	tagStripOffsets              = 273
+	tagSamplesPerPixel           = 277
+	tagRowsPerStrip              = 278
+	tagStripByteCounts           = 279
+	tagXResolution               = 282
+	tagYResolution               = 283
+	tagPlanarConfiguration       = 284
+	tagResolutionUnit            = 296
+	tagPredictor                 = 317
+	tagExtraSamples              = 338
+	tagModelPixelScale           = 33550
+	tagModelTiepoint             = 33922
+	tagModelTransformation       = 34264
+	tagGeoKeyDirectory           = 34735
+	tagGeoASCIIParams            = 34737
+)
+
+// TIFF field types
+const (
+	typeASCII    = 2
+	typeShort    = 3
+	typeLong     = 4
+	typeRational = 5
+	typeDouble   = 12
+)
+
+// GeoTIFF keys and values
+const (
+	keyGTModelType      = 1024
+	keyGTRasterType     = 1025
+	keyGTCitation       = 1026
+	keyProjectedCSType  = 3072
+	keyProjLinearUnits  = 3076
+	modelTypeProjected  = 1
+	rasterPixelIsArea   = 1
+	epsgWebMercator     = 3857
+	linearMeter         = 9001
+	webMercatorCitation = "WGS 84 / Pseudo-Mercator"
+)
+
+// stripSize is the approximate number of uncompressed bytes in each strip
+const stripSize = 64 * 1024
+
+// TIFFDeflate sets whether GeoTIFF images are compressed with deflate.
+// Compressed images use horizontal differencing, which GDAL and QGIS read.
+// Images are compressed by default.
+func TIFFDeflate(deflate bool) EncodeOption {
+	return func(o *encodeOptions) {
+		o.tiffUncompressed = !deflate
+	}
+}
+
+// EncodeGeoTIFF writes img to w as a GeoTIFF georeferenced by geo in Web
+// Mercator (EPSG:3857), for example from TilesGeoreference with the same
+// tiles and options passed to Merge.
+// Images with any transparent pixels are written with an alpha channel;
+// other images are written as RGB.  Images rotated with the Bearing option
+// are georeferenced with a model transformation instead of a tiepoint and
+// pixel scale.
+func EncodeGeoTIFF(w io.Writer, img image.Image, geo Georeference, opts ...EncodeOption) error {
+	o := newEncodeOptions(opts)
+
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 {
+		return errors.New("tilemerge: GeoTIFF image must not be empty")
+	}
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+
+	samples := 3
+	for i := 3; i < len(nrgba.Pix); i += 4 {
+		if nrgba.Pix[i] != 0xff {
+			samples = 4
+			break
+		}
+	}
+
+	rowsPerStrip := stripSize / (width * samples)
+	if rowsPerStrip < 1 {
+		rowsPerStrip = 1
+	}
+	if rowsPerStrip > height {
+		rowsPerStrip = height
+	}
+
+	// image data follows the 8 byte header
+	var data bytes.Buffer
+	var offsets, counts []uint32
+	row := make([]byte, width*samples)
+	for y0 := 0; y0 < height; y0 += rowsPerStrip {
+		y1 := y0 + rowsPerStrip
+		if y1 > height {
+			y1 = height
+		}
+
+		start := data.Len()
+		var strip io.Writer = &data
+		var zw *zlib.Writer
+		if !o.tiffUncompressed {
+			zw = zlib.NewWriter(&data)
+			strip = zw
+		}
+		for y := y0; y < y1; y++ {
+			pix := nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+width*4]
+			for x := 0; x < width; x++ {
+				copy(row[x*samples:(x+1)*samples], pix[x*4:x*4+samples])
+			}
+			if zw != nil {
+				// horizontal differencing: each sample is stored as the
+				// difference from the same sample of the pixel to its left
+				for i := len(row) - 1; i >= samples; i-- {
+					row[i] -= row[i-samples]
+				}
+			}
+			strip.Write(row)
+		}
+		if zw != nil {
+			if err := zw.Close(); err != nil {
+				return err
+			}
+		}
+
+		offsets = append(offsets, uint32(8+start))
+		counts = append(counts, uint32(data.Len()-start))
+		if data.Len()%2 == 1 {
+			// keep strips and the IFD on word boundaries
+			data.WriteByte(0)
+		}
+	}
+
+	bits := make([]uint16, samples)
+	for i := range bits {
+		bits[i] = 8
+	}
+	compression, predictor := uint16(8), uint16(2)
+	if o.tiffUncompressed {
+		compression, predictor = 1, 1
+	}
+
+	ifd := ifdEntries{
+		{tagImageWidth, typeLong, []uint32{uint32(width)}},
+		{tagImageLength, typeLong, []uint32{uint32(height)}},
+		{tagBitsPerSample, typeShort, bits},
+		{tagCompression, typeShort, []uint16{compression}},
+		{tagPhotometricInterpretation, typeShort, []uint16{2}}, // RGB
+		{tagStripOffsets, typeLong, offsets},
+		{tagSamplesPerPixel, typeShort, []uint16{uint16(samples)}},
+		{tagRowsPerStrip, typeLong, []uint32{uint32(rowsPerStrip)}},
+		{tagStripByteCounts, typeLong, counts},
+		{tagXResolution, typeRational, []uint32{72, 1}},
+		{tagYResolution, typeRational, []uint32{72, 1}},
+		{tagPlanarConfiguration, typeShort, []uint16{1}}, // chunky
+		{tagResolutionUnit, typeShort, []uint16{2}},      // inch
+		{tagPredictor, typeShort, []uint16{predictor}},
+	}
+	if samples == 4 {
+		ifd = append(ifd, ifdEntry{tagExtraSamples, typeShort, []uint16{2}}) // unassociated alpha
+	}
+	if geo.Bearing == 0 {
+		ifd = append(ifd,
+			ifdEntry{tagModelPixelScale, typeDouble, []float64{geo.PixelSize, geo.PixelSize, 0}},
+			ifdEntry{tagModelTiepoint, typeDouble, []float64{0, 0, 0, geo.X, geo.Y, 0}},
+		)
+	} else {
+		// rotated images need the full transform from pixels to meters
+		t := geo.transform()
+		ifd = append(ifd, ifdEntry{tagModelTransformation, typeDouble, []float64{
+			t[1], t[2], 0, t[0],
+			t[4], t[5], 0, t[3],
+			0, 0, 0, 0,
+			0, 0, 0, 1,
+		}})
+	}
+	ifd = append(ifd,
+		ifdEntry{tagGeoKeyDirectory, typeShort, []uint16{
+			1, 1, 0, 5, // version 1.1.0, with 5 keys
+			keyGTModelType, 0, 1, modelTypeProjected,
+			keyGTRasterType, 0, 1, rasterPixelIsArea,
+			keyGTCitation, tagGeoASCIIParams, uint16(len(webMercatorCitation) + 1), 0,
+			keyProjectedCSType, 0, 1, epsgWebMercator,
+			keyProjLinearUnits, 0, 1, linearMeter,
+		}},
+		ifdEntry{tagGeoASCIIParams, typeASCII, []byte(webMercatorCitation + "|\x00")},
+	)
+
+	header := []byte{'I', 'I', 42, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(header[4:], uint32(8+data.Len()))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(ifd.encode(uint32(8 + data.Len())))
+	return err
+}
+
+// ifdEntry is a field of a TIFF image file directory.  value is a []byte,
+// []uint16, []uint32 or []float64 of the values of the field.
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	value interface{}
+}
+
+// ifdEntries are the fields of a TIFF image file directory, in order of tag
+type ifdEntries []ifdEntry
+
+// encode returns the little endian image file directory, followed by the
+// values of its fields that do not fit in the directory, for a directory at
+// offset bytes from the start of the file
+func (entries ifdEntries) encode(offset uint32) []byte {
+	var buf, values bytes.Buffer
+	le := binary.LittleEndian
+
+	// directory is the number of entries, the entries, and the offset of the
+	// next directory
+	valuesOffset := offset + 2 + 12*uint32(len(entries)) + 4
+
+	binary.Write(&buf, le, uint16(len(entries)))
+	for _, e := range entries {
+		var v bytes.Buffer
+		binary.Write(&v, le, e.value)
+
+		var count int
+		switch value := e.value.(type) {
+		case []byte:
+			count = len(value)
+		case []uint16:
+			count = len(value)
+		case []uint32:
+			count = len(value)
+			if e.typ == typeRational {
+				count /= 2
+			}
+		case []float64:
+			count = len(value)
+		}
+
+		binary.Write(&buf, le, e.tag)
+		binary.Write(&buf, le, e.typ)
+		binary.Write(&buf, le, uint32(count))
+		if v.Len() <= 4 {
+			for v.Len() < 4 {
+				v.WriteByte(0)
+			}
+			buf.Write(v.Bytes())
+			continue
+		}
+		binary.Write(&buf, le, valuesOffset+uint32(values.Len()))
+		values.Write(v.Bytes())
+		if values.Len()%2 == 1 {
+			values.WriteByte(0)
+		}
+	}
+	binary.Write(&buf, le, uint32(0)) // no more directories
+
+	buf.Write(values.Bytes())
+	return buf.Bytes()
+}
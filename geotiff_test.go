@@ -0,0 +1,241 @@
+package tilemerge
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+// tiffField is a field of a TIFF image file directory, with its values
+// converted to float64
+type tiffField struct {
+	typ    uint16
+	values []float64
+}
+
+// readTIFF reads the fields of the first image file directory of a little
+// endian TIFF, and the image it describes
+func readTIFF(t *testing.T, data []byte) (map[uint16]tiffField, *image.NRGBA) {
+	le := binary.LittleEndian
+	if string(data[:4]) != "II*\x00" {
+		t.Fatalf("TIFF header is %q, expected little endian", data[:4])
+	}
+
+	fields := make(map[uint16]tiffField)
+	offset := le.Uint32(data[4:])
+	n := int(le.Uint16(data[offset:]))
+	for i := 0; i < n; i++ {
+		entry := data[int(offset)+2+12*i:]
+		tag, typ, count := le.Uint16(entry), le.Uint16(entry[2:]), int(le.Uint32(entry[4:]))
+
+		size := map[uint16]int{typeASCII: 1, typeShort: 2, typeLong: 4, typeRational: 8, typeDouble: 8}[typ]
+		value := entry[8:12]
+		if size*count > 4 {
+			value = data[le.Uint32(entry[8:]):]
+		}
+
+		field := tiffField{typ: typ}
+		for j := 0; j < count; j++ {
+			var v float64
+			switch typ {
+			case typeASCII:
+				v = float64(value[j])
+			case typeShort:
+				v = float64(le.Uint16(value[2*j:]))
+			case typeLong:
+				v = float64(le.Uint32(value[4*j:]))
+			case typeRational:
+				v = float64(le.Uint32(value[8*j:])) / float64(le.Uint32(value[8*j+4:]))
+			case typeDouble:
+				v = math.Float64frombits(le.Uint64(value[8*j:]))
+			}
+			field.values = append(field.values, v)
+		}
+		fields[tag] = field
+	}
+
+	width, height := int(fields[tagImageWidth].values[0]), int(fields[tagImageLength].values[0])
+	samples := int(fields[tagSamplesPerPixel].values[0])
+	rowsPerStrip := int(fields[tagRowsPerStrip].values[0])
+	compressed := fields[tagCompression].values[0] == 8
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, stripOffset := range fields[tagStripOffsets].values {
+		strip := data[int(stripOffset) : int(stripOffset)+int(fields[tagStripByteCounts].values[i])]
+		if compressed {
+			zr, err := zlib.NewReader(bytes.NewReader(strip))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strip, err = ioutil.ReadAll(zr); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for r := 0; r < rowsPerStrip && i*rowsPerStrip+r < height; r++ {
+			row := strip[r*width*samples : (r+1)*width*samples]
+			if fields[tagPredictor].values[0] == 2 {
+				for j := samples; j < len(row); j++ {
+					row[j] += row[j-samples]
+				}
+			}
+			y := i*rowsPerStrip + r
+			for x := 0; x < width; x++ {
+				p := img.Pix[y*img.Stride+x*4:]
+				copy(p[:samples], row[x*samples:])
+				if samples == 3 {
+					p[3] = 0xff
+				}
+			}
+		}
+	}
+	return fields, img
+}
+
+func Test_EncodeGeoTIFF(t *testing.T) {
+	tiles := jpgTiles()
+	img, err := Merge(tiles, 100, 50, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	geo, err := TilesGeoreference(tiles, 100, 50, 300, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeGeoTIFF(&buf, img, geo); err != nil {
+		t.Fatal(err)
+	}
+	fields, decoded := readTIFF(t, buf.Bytes())
+
+	verifyDimensions(t, decoded, 300, 200)
+	if diff := maxDifference(toRGBA(decoded), img); diff != 0 {
+		t.Errorf("GeoTIFF image differs from merged image by %v", diff)
+	}
+
+	expected := map[uint16][]float64{
+		tagCompression:     {8},
+		tagSamplesPerPixel: {3},
+		tagPredictor:       {2},
+		tagModelPixelScale: {geo.PixelSize, geo.PixelSize, 0},
+		tagModelTiepoint:   {0, 0, 0, geo.X, geo.Y, 0},
+		tagGeoKeyDirectory: {
+			1, 1, 0, 5,
+			keyGTModelType, 0, 1, modelTypeProjected,
+			keyGTRasterType, 0, 1, rasterPixelIsArea,
+			keyGTCitation, tagGeoASCIIParams, 25, 0,
+			keyProjectedCSType, 0, 1, 3857,
+			keyProjLinearUnits, 0, 1, linearMeter,
+		},
+	}
+	for tag, values := range expected {
+		if actual := fields[tag].values; !equalFloats(actual, values) {
+			t.Errorf("GeoTIFF tag %v is %v, expected %v", tag, actual, values)
+		}
+	}
+	if _, ok := fields[tagExtraSamples]; ok {
+		t.Error("GeoTIFF of opaque image has extra samples")
+	}
+}
+
+func Test_EncodeGeoTIFF_Alpha(t *testing.T) {
+	tiles := jpgTiles()
+	tiles.Tiles[3].Data = nil
+	img, err := Merge(tiles, 0, 0, 512, 512, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeGeoTIFF(&buf, img, Georeference{}); err != nil {
+		t.Fatal(err)
+	}
+	fields, decoded := readTIFF(t, buf.Bytes())
+
+	if samples := fields[tagSamplesPerPixel].values; !equalFloats(samples, []float64{4}) {
+		t.Errorf("GeoTIFF of transparent image has %v samples per pixel, expected 4", samples)
+	}
+	if extra := fields[tagExtraSamples].values; !equalFloats(extra, []float64{2}) {
+		t.Errorf("GeoTIFF of transparent image has extra samples %v, expected unassociated alpha", extra)
+	}
+	if diff := maxDifference(toRGBA(decoded), img); diff != 0 {
+		t.Errorf("GeoTIFF image differs from merged image by %v", diff)
+	}
+}
+
+func Test_EncodeGeoTIFF_Uncompressed(t *testing.T) {
+	img, err := Merge(jpgTiles(), 0, 0, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var compressed, uncompressed bytes.Buffer
+	if err := EncodeGeoTIFF(&compressed, img, Georeference{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeGeoTIFF(&uncompressed, img, Georeference{}, TIFFDeflate(false)); err != nil {
+		t.Fatal(err)
+	}
+	if compressed.Len() >= uncompressed.Len() {
+		t.Errorf("compressed GeoTIFF is %v bytes, expected less than %v uncompressed", compressed.Len(), uncompressed.Len())
+	}
+
+	fields, decoded := readTIFF(t, uncompressed.Bytes())
+	if compression := fields[tagCompression].values; !equalFloats(compression, []float64{1}) {
+		t.Errorf("uncompressed GeoTIFF has compression %v, expected 1", compression)
+	}
+	if diff := maxDifference(toRGBA(decoded), img); diff != 0 {
+		t.Errorf("uncompressed GeoTIFF image differs from merged image by %v", diff)
+	}
+}
+
+func Test_EncodeGeoTIFF_Bearing(t *testing.T) {
+	img, err := Merge(jpgTiles(), 136, 176, 240, 160, nil, Bearing(90))
+	if err != nil {
+		t.Fatal(err)
+	}
+	geo, err := TilesGeoreference(jpgTiles(), 136, 176, 240, 160, Bearing(90))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeGeoTIFF(&buf, img, geo); err != nil {
+		t.Fatal(err)
+	}
+	fields, _ := readTIFF(t, buf.Bytes())
+
+	// moving right the image moves south, and moving down moves west
+	s := geo.PixelSize
+	expected := []float64{0, -s, 0, geo.X, -s, 0, 0, geo.Y, 0, 0, 0, 0, 0, 0, 0, 1}
+	actual := fields[tagModelTransformation].values
+	if len(actual) != len(expected) {
+		t.Fatalf("GeoTIFF model transformation is %v, expected %v", actual, expected)
+	}
+	for i := range expected {
+		if math.Abs(actual[i]-expected[i]) > 1e-6 {
+			t.Errorf("GeoTIFF model transformation is %v, expected %v", actual, expected)
+			break
+		}
+	}
+	if _, ok := fields[tagModelTiepoint]; ok {
+		t.Error("GeoTIFF of rotated image has a tiepoint")
+	}
+}
+
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package tilemerge
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPSource is a TileSource that requests tiles from a tile server.
+//
+// The URL of each tile is created from a template, which may contain the
+// placeholders supported by DirectorySource, as well as:
+//
+//	{s}  subdomain, chosen from Subdomains
+//	{r}  "@2x" if Retina is true, otherwise empty
+//
+// For example, "https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png".
+//
+// A tile that the server responds to with 404 Not Found or 204 No Content is
+// missing.  Requests that fail due to network errors, 429 Too Many Requests,
+// or 5xx server errors are retried, waiting RetryDelay before the first retry
+// and doubling the wait before each subsequent retry.
+type HTTPSource struct {
+	Template      string
+	Subdomains    []string
+	Retina        bool
+	Header        http.Header // added to each request, e.g. User-Agent or API keys
+	Client        *http.Client
+	MaxConcurrent int // maximum number of requests in progress at once
+	MaxRetries    int
+	RetryDelay    time.Duration
+
+	once sync.Once
+	sem  chan struct{}
+}
+
+// NewHTTPSource returns an HTTPSource for tiles with URLs created from
+// template, with Leaflet's default subdomains "a", "b", and "c", up to 4
+// requests at once, and up to 2 retries of failed requests.
+func NewHTTPSource(template string) *HTTPSource {
+	return &HTTPSource{
+		Template:      template,
+		Subdomains:    []string{"a", "b", "c"},
+		Header:        make(http.Header),
+		Client:        http.DefaultClient,
+		MaxConcurrent: 4,
+		MaxRetries:    2,
+		RetryDelay:    250 * time.Millisecond,
+	}
+}
+
+// URL returns the URL of the tile at z, x, y
+func (s *HTTPSource) URL(z uint8, x, y int) string {
+	subdomain := ""
+	if len(s.Subdomains) > 0 {
+		// same as Leaflet, so that tiles are requested from the same subdomain
+		i := x + y
+		if i < 0 {
+			i = -i
+		}
+		subdomain = s.Subdomains[i%len(s.Subdomains)]
+	}
+
+	retina := ""
+	if s.Retina {
+		retina = "@2x"
+	}
+
+	url := strings.NewReplacer("{s}", subdomain, "{r}", retina).Replace(s.Template)
+	return expandTemplate(url, z, x, y)
+}
+
+// GetTile requests the tile at z, x, y from the tile server.
+// It returns nil if the server does not have the tile.
+func (s *HTTPSource) GetTile(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+	s.once.Do(func() {
+		if s.MaxConcurrent > 0 {
+			s.sem = make(chan struct{}, s.MaxConcurrent)
+		}
+	})
+
+	url := s.URL(z, x, y)
+	delay := s.RetryDelay
+	for attempt := 0; ; attempt++ {
+		data, retry, err := s.get(ctx, url)
+		if !retry || attempt >= s.MaxRetries {
+			return data, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// get makes a single request for url, and returns whether the request
+// should be retried if it failed
+func (s *HTTPSource) get(ctx context.Context, url string) (data []byte, retry bool, err error) {
+	if s.sem != nil {
+		select {
+		case s.sem <- struct{}{}:
+			defer func() { <-s.sem }()
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req = req.WithContext(ctx)
+	for key, values := range s.Header {
+		req.Header[key] = values
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// do not retry if the request was canceled
+		return nil, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		data, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, ctx.Err() == nil, err
+		}
+		return data, false, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent:
+		return nil, false, nil
+	}
+
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return nil, retry, fmt.Errorf("tilemerge: GET %v: %v", url, resp.Status)
+}
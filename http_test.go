@@ -0,0 +1,251 @@
+package tilemerge
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tileServer serves the files in test_data, with the first path segment
+// used as the subdomain, e.g. /a/4_3_5.png
+func tileServer(handler func(w http.ResponseWriter, r *http.Request) bool) *httptest.Server {
+	files := http.FileServer(http.Dir("test_data"))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler != nil && !handler(w, r) {
+			return
+		}
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		r.URL.Path = "/" + parts[len(parts)-1]
+		files.ServeHTTP(w, r)
+	}))
+}
+
+func Test_HTTPSource_URL(t *testing.T) {
+	cases := []struct {
+		template string
+		retina   bool
+		x, y     int
+		url      string
+	}{
+		{"https://{s}.tile.example.com/{z}/{x}/{y}.png", false, 3, 5, "https://c.tile.example.com/4/3/5.png"},
+		{"https://{s}.tile.example.com/{z}/{x}/{y}.png", false, 2, 5, "https://b.tile.example.com/4/2/5.png"},
+		{"https://tile.example.com/{z}/{x}/{y}{r}.png", true, 3, 5, "https://tile.example.com/4/3/5@2x.png"},
+		{"https://tile.example.com/{z}/{x}/{y}{r}.png", false, 3, 5, "https://tile.example.com/4/3/5.png"},
+		{"https://tile.example.com/{z}/{x}/{-y}.png", false, 3, 5, "https://tile.example.com/4/3/10.png"},
+		{"https://tile.example.com/{q}.jpeg", false, 3, 5, "https://tile.example.com/0213.jpeg"},
+	}
+
+	for _, c := range cases {
+		src := NewHTTPSource(c.template)
+		src.Retina = c.retina
+		if url := src.URL(4, c.x, c.y); url != c.url {
+			t.Errorf("URL(4, %v, %v) with template %q = %q, expected %q", c.x, c.y, c.template, url, c.url)
+		}
+	}
+}
+
+func Test_HTTPSource_GetTile(t *testing.T) {
+	var mu sync.Mutex
+	subdomains := make(map[string]bool)
+	server := tileServer(func(w http.ResponseWriter, r *http.Request) bool {
+		mu.Lock()
+		subdomains[strings.Split(r.URL.Path, "/")[1]] = true
+		mu.Unlock()
+
+		if r.Header.Get("User-Agent") != "tilemerge-test" || r.Header.Get("X-Api-Key") != "secret" {
+			http.Error(w, "missing headers", http.StatusForbidden)
+			return false
+		}
+		return true
+	})
+	defer server.Close()
+
+	src := NewHTTPSource(server.URL + "/{s}/{z}_{x}_{y}.png")
+	src.Header.Set("User-Agent", "tilemerge-test")
+	src.Header.Set("X-Api-Key", "secret")
+
+	files := testTiles("png")
+	for y := 5; y <= 6; y++ {
+		for x := 2; x <= 4; x++ {
+			data, err := src.GetTile(context.Background(), 4, x, y)
+			if err != nil {
+				t.Fatalf("GetTile(4, %v, %v) returned error: %v", x, y, err)
+			}
+
+			expected, _ := files.GetTile(context.Background(), 4, x, y)
+			if !bytes.Equal(data, expected) {
+				t.Errorf("GetTile(4, %v, %v) did not return the tile file", x, y)
+			}
+		}
+	}
+
+	if len(subdomains) != 3 {
+		t.Errorf("GetTile() requested from subdomains %v, expected a, b, and c", subdomains)
+	}
+}
+
+func Test_HTTPSource_Missing(t *testing.T) {
+	server := tileServer(nil)
+	defer server.Close()
+
+	src := NewHTTPSource(server.URL + "/{s}/{z}_{x}_{y}.png")
+	data, err := src.GetTile(context.Background(), 4, 0, 0)
+	if err != nil {
+		t.Errorf("GetTile() for missing tile returned error: %v", err)
+	}
+	if data != nil {
+		t.Error("GetTile() for missing tile returned data")
+	}
+}
+
+func Test_HTTPSource_Retry(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	server := tileServer(func(w http.ResponseWriter, r *http.Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return false
+		}
+		return true
+	})
+	defer server.Close()
+
+	src := NewHTTPSource(server.URL + "/{s}/{z}_{x}_{y}.png")
+	src.RetryDelay = time.Millisecond
+
+	data, err := src.GetTile(context.Background(), 4, 3, 5)
+	if err != nil {
+		t.Fatalf("GetTile() returned error after retries: %v", err)
+	}
+	if data == nil || requests != 3 {
+		t.Errorf("GetTile() made %v requests, expected 3", requests)
+	}
+
+	// too many failures
+	requests = 0
+	src.MaxRetries = 1
+	if _, err := src.GetTile(context.Background(), 4, 3, 5); err == nil {
+		t.Error("GetTile() did not return an error when retries were exhausted")
+	}
+	if requests != 2 {
+		t.Errorf("GetTile() made %v requests, expected 2", requests)
+	}
+}
+
+func Test_HTTPSource_No_Retry(t *testing.T) {
+	requests := 0
+	server := tileServer(func(w http.ResponseWriter, r *http.Request) bool {
+		requests++
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	})
+	defer server.Close()
+
+	src := NewHTTPSource(server.URL + "/{s}/{z}_{x}_{y}.png")
+	src.RetryDelay = time.Millisecond
+
+	if _, err := src.GetTile(context.Background(), 4, 3, 5); err == nil {
+		t.Error("GetTile() did not return an error for 403 Forbidden")
+	}
+	if requests != 1 {
+		t.Errorf("GetTile() made %v requests, expected 1", requests)
+	}
+}
+
+func Test_HTTPSource_MaxConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	active := 0
+	maxActive := 0
+	server := tileServer(func(w http.ResponseWriter, r *http.Request) bool {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return true
+	})
+	defer server.Close()
+
+	src := NewHTTPSource(server.URL + "/{s}/{z}_{x}_{y}.png")
+	src.MaxConcurrent = 2
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := src.GetTile(context.Background(), 4, 2+i%3, 5+i%2); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if maxActive > 2 {
+		t.Errorf("HTTPSource made %v requests at once, expected at most 2", maxActive)
+	}
+}
+
+// Merging from a tile server should produce the same output as merging the tiles from disk
+func Test_MergeSource_HTTP(t *testing.T) {
+	server := tileServer(nil)
+	defer server.Close()
+
+	expected, err := MergeSource(context.Background(), testTiles("png"), 4, 2, 5, 100, 50, 600, 400, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	src := NewHTTPSource(server.URL + "/{s}/{z}_{x}_{y}.png")
+	img, err := MergeSource(context.Background(), src, 4, 2, 5, 100, 50, 600, 400, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if !bytes.Equal(img.(*image.RGBA).Pix, expected.(*image.RGBA).Pix) {
+		t.Error("MergeSource() from HTTPSource did not match tiles from disk")
+	}
+}
+
+// A context timeout should cancel requests to a slow tile server
+func Test_MergeSource_HTTP_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := tileServer(func(w http.ResponseWriter, r *http.Request) bool {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+		return true
+	})
+	defer server.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	src := NewHTTPSource(server.URL + "/{s}/{z}_{x}_{y}.png")
+	start := time.Now()
+	_, err := MergeSource(ctx, src, 4, 2, 5, 0, 0, 768, 512, nil, Workers(4))
+	if err != context.DeadlineExceeded {
+		t.Errorf("MergeSource() returned error %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("MergeSource() took %v to stop after the context timed out", elapsed)
+	}
+}
@@ -0,0 +1,117 @@
+package jpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	_ "image/png"
+)
+
+func readImage(path string) image.Image {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		panic(err)
+	}
+	return img
+}
+
+// stripes returns an image of one pixel wide red and blue stripes, which
+// lose their color when chroma is subsampled
+func stripes() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x%2 == 1 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func Test_Encode_420(t *testing.T) {
+	images := map[string]image.Image{
+		"png tile": readImage("../../test_data/4_3_5.png"),
+		"stripes":  stripes(),
+		"gray":     image.NewGray(image.Rect(0, 0, 30, 20)),
+	}
+	for name, img := range images {
+		for _, quality := range []int{10, 75, 100} {
+			var actual, expected bytes.Buffer
+			if err := Encode(&actual, img, &Options{Quality: quality}); err != nil {
+				t.Fatalf("%v: Encode() returned error: %v", name, err)
+			}
+			if err := jpeg.Encode(&expected, img, &jpeg.Options{Quality: quality}); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual.Bytes(), expected.Bytes()) {
+				t.Errorf("%v: encoded image at quality %v does not match image/jpeg", name, quality)
+			}
+		}
+	}
+}
+
+func Test_Encode_444(t *testing.T) {
+	img := stripes()
+	errs := make(map[Subsampling]int)
+	for _, subsampling := range []Subsampling{Subsample420, Subsample444} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, &Options{Quality: 90, Subsampling: subsampling}); err != nil {
+			t.Fatalf("Encode() returned error: %v", err)
+		}
+
+		decoded, err := jpeg.Decode(&buf)
+		if err != nil {
+			t.Fatalf("decoding encoded image returned error: %v", err)
+		}
+		ycbcr, ok := decoded.(*image.YCbCr)
+		if !ok {
+			t.Fatalf("decoded image is %T, expected *image.YCbCr", decoded)
+		}
+		expected := image.YCbCrSubsampleRatio420
+		if subsampling == Subsample444 {
+			expected = image.YCbCrSubsampleRatio444
+		}
+		if ycbcr.SubsampleRatio != expected {
+			t.Errorf("decoded image has subsample ratio %v, expected %v", ycbcr.SubsampleRatio, expected)
+		}
+		if ycbcr.Bounds() != img.Bounds() {
+			t.Errorf("decoded image is %v, expected %v", ycbcr.Bounds(), img.Bounds())
+		}
+
+		for y := 0; y < 48; y++ {
+			for x := 0; x < 64; x++ {
+				r0, _, b0, _ := img.At(x, y).RGBA()
+				r1, _, b1, _ := ycbcr.At(x, y).RGBA()
+				errs[subsampling] += absDiff(r0>>8, r1>>8) + absDiff(b0>>8, b1>>8)
+			}
+		}
+	}
+
+	n := 64 * 48 * 2
+	if errs[Subsample444] > 4*n {
+		t.Errorf("mean error of 4:4:4 is %v, expected at most 4", errs[Subsample444]/n)
+	}
+	if errs[Subsample444]*10 > errs[Subsample420] {
+		t.Errorf("error of 4:4:4 (%v) is not much less than 4:2:0 (%v)", errs[Subsample444], errs[Subsample420])
+	}
+}
+
+func absDiff(a, b uint32) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
@@ -0,0 +1,100 @@
+package tilemerge
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Layer is a set of tiles to be merged together with other layers.
+// The zero values of its fields draw an opaque layer over the layers below.
+type Layer struct {
+	Source       TileSource
+	Transparency float64 // from 0 (opaque) to 1 (transparent)
+	Blend        BlendMode
+
+	// TileSize is the size of the tiles of this layer, if different from the
+	// tile size used for merging.  The layer is scaled to line up with the
+	// other layers, for example to merge 512 pixel @2x tiles with 256 pixel tiles.
+	TileSize int
+}
+
+// NewLayer returns an opaque Layer of tiles from src, drawn over the layers below it
+func NewLayer(src TileSource) Layer {
+	return Layer{Source: src, Blend: BlendNormal}
+}
+
+// MergeLayers merges several layers of tiles at zoom z into a single Image,
+// like MergeSource, including wrapping tile columns across the anti-meridian.
+// Layers are merged in order from the bottom up; the first layer is drawn
+// over `bg`, if provided.  All layers share the same area, cropped based on
+// xOff, yOff from the upper left of tile x0, y0 in the tile size set by the
+// TileSize option (AutoTileSize is treated as TILE_SIZE).
+//
+// The Composite option does not apply to layers; layers are blended with the
+// layers below them according to their Blend and Transparency.
+func MergeLayers(ctx context.Context, layers []Layer, z uint8, x0, y0, xOff, yOff, width, height int, bg color.Color, opts ...Option) (image.Image, error) {
+	o := newOptions(opts)
+	if o.tileSize == AutoTileSize {
+		o.tileSize = TILE_SIZE
+	}
+	layers = worldLayers(layers)
+
+	return mergeOutput(o, z, x0*o.tileSize+xOff, y0*o.tileSize+yOff, width, height, func(x, y, width, height int) (image.Image, error) {
+		return mergeLayers(ctx, layers, z, x, y, width, height, bg, o)
+	})
+}
+
+// MergeViewLayers merges several layers of tiles covered by view into a
+// single Image, like MergeView and MergeLayers.
+func MergeViewLayers(ctx context.Context, layers []Layer, view View, bg color.Color, opts ...Option) (image.Image, error) {
+	o := newOptions(opts)
+	o.tileSize = view.TileSize
+	layers = worldLayers(layers)
+
+	return mergeView(view, o, func(z uint8, x, y, width, height int) (image.Image, error) {
+		return mergeLayers(ctx, layers, z, x, y, width, height, bg, o)
+	})
+}
+
+// worldLayers returns a copy of layers with their sources wrapped across the
+// anti-meridian, as MergeView does
+func worldLayers(layers []Layer) []Layer {
+	wrapped := make([]Layer, len(layers))
+	for i, layer := range layers {
+		wrapped[i] = layer
+		wrapped[i].Source = newWorldSource(layer.Source)
+	}
+	return wrapped
+}
+
+// mergeLayers merges each layer into the area of width by height pixels with
+// its upper left corner at pixel x, y, and blends it with the layers below
+func mergeLayers(ctx context.Context, layers []Layer, z uint8, x, y, width, height int, bg color.Color, o *options) (image.Image, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	if bg != nil {
+		draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.ZP, draw.Src)
+	}
+
+	for _, layer := range layers {
+		lo := *o
+		lo.op = draw.Src
+		if layer.TileSize > 0 {
+			lo.tileSize = layer.TileSize
+		}
+
+		// scale from pixels of this layer to pixels of the merged image
+		scale := float64(o.tileSize) / float64(lo.tileSize)
+		merged, err := mergeScaled(scale, 0, Bilinear, float64(x), float64(y), width, height, func(x, y, width, height int) (image.Image, error) {
+			return merge(ctx, layer.Source, z, x, y, width, height, nil, &lo)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		layer.Blend.Draw(img, img.Bounds(), merged, image.ZP, 1-layer.Transparency)
+	}
+
+	return img, nil
+}
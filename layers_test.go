@@ -0,0 +1,178 @@
+package tilemerge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// retinaSource returns @2x tiles by scaling up tiles from src
+type retinaSource struct {
+	src TileSource
+}
+
+func (s retinaSource) GetTile(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+	data, err := s.src.GetTile(ctx, z, x, y)
+	if err != nil || data == nil {
+		return data, err
+	}
+
+	tile, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	b := tile.Bounds()
+	large := image.NewNRGBA(image.Rect(0, 0, b.Dx()*2, b.Dy()*2))
+	for py := 0; py < b.Dy()*2; py++ {
+		for px := 0; px < b.Dx()*2; px++ {
+			large.Set(px, py, tile.At(b.Min.X+px/2, b.Min.Y+py/2))
+		}
+	}
+	return *encodePNG(large), nil
+}
+
+// A single opaque layer is the same as merging tiles over the background
+func Test_MergeLayers_Single(t *testing.T) {
+	bg := color.RGBA{135, 206, 235, 255}
+	expected, err := MergeSource(context.Background(), testTiles("png"), 4, 2, 5, 50, 20, 600, 400, bg, Composite(draw.Over))
+	if err != nil {
+		panic(err)
+	}
+
+	layers := []Layer{NewLayer(testTiles("png"))}
+	img, err := MergeLayers(context.Background(), layers, 4, 2, 5, 50, 20, 600, 400, bg)
+	if err != nil {
+		panic(err)
+	}
+
+	// layers are composited after premultiplying alpha, which may round differently
+	if diff := maxDifference(img, expected); diff > 1 {
+		t.Errorf("MergeLayers() with a single layer did not match MergeSource(): max difference %v", diff)
+	}
+}
+
+func Test_MergeLayers_Opacity(t *testing.T) {
+	overlay := NewLayer(testTiles("png"))
+	overlay.Transparency = 0.5
+	layers := []Layer{NewLayer(testTiles("webp")), overlay}
+
+	img, err := MergeLayers(context.Background(), layers, 4, 3, 5, 0, 0, 512, 512, color.White)
+	if err != nil {
+		panic(err)
+	}
+
+	if *update {
+		exportPNG(img, "test_data/output/test_layers_opacity.png")
+	}
+
+	verifyDimensions(t, img, 512, 512)
+	verifyPNG(t, img, "test_data/output/test_layers_opacity.png")
+}
+
+// A transparent layer should not change the layers below it
+func Test_MergeLayers_Transparent(t *testing.T) {
+	expected, err := MergeSource(context.Background(), testTiles("webp"), 4, 3, 5, 0, 0, 512, 512, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	overlay := NewLayer(testTiles("png"))
+	overlay.Transparency = 1
+	layers := []Layer{NewLayer(testTiles("webp")), overlay}
+	img, err := MergeLayers(context.Background(), layers, 4, 3, 5, 0, 0, 512, 512, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if !bytes.Equal(img.(*image.RGBA).Pix, expected.(*image.RGBA).Pix) {
+		t.Error("MergeLayers() with a transparent layer changed the layers below it")
+	}
+}
+
+// A Layer literal should be drawn the same as one from NewLayer
+func Test_MergeLayers_Zero_Value(t *testing.T) {
+	expected, err := MergeLayers(context.Background(), []Layer{NewLayer(testTiles("png"))}, 4, 3, 5, 0, 0, 512, 512, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	img, err := MergeLayers(context.Background(), []Layer{{Source: testTiles("png")}}, 4, 3, 5, 0, 0, 512, 512, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if !bytes.Equal(img.(*image.RGBA).Pix, expected.(*image.RGBA).Pix) {
+		t.Error("MergeLayers() with a Layer literal did not match NewLayer()")
+	}
+}
+
+// A layer with @2x tiles should line up with the layers at the merged tile size
+func Test_MergeLayers_TileSize(t *testing.T) {
+	expected, err := MergeSource(context.Background(), testTiles("png"), 4, 2, 5, 100, 50, 600, 400, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	layer := NewLayer(retinaSource{testTiles("png")})
+	layer.TileSize = 2 * TILE_SIZE
+	img, err := MergeLayers(context.Background(), []Layer{layer}, 4, 2, 5, 100, 50, 600, 400, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	verifyDimensions(t, img, 600, 400)
+	// scaling blurs edges slightly
+	if diff := meanDifference(img, expected); diff > 1 {
+		t.Errorf("MergeLayers() with @2x tiles did not line up with 256 pixel tiles: mean difference %v", diff)
+	}
+}
+
+func Test_MergeViewLayers(t *testing.T) {
+	view, err := ViewportView(-98.5, 39.8, 4.25, 640, 481, TILE_SIZE)
+	if err != nil {
+		panic(err)
+	}
+
+	expected, err := MergeView(context.Background(), testTiles("png"), view, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	img, err := MergeViewLayers(context.Background(), []Layer{NewLayer(testTiles("png"))}, view, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if !bytes.Equal(img.(*image.RGBA).Pix, expected.(*image.RGBA).Pix) {
+		t.Error("MergeViewLayers() with a single layer did not match MergeView()")
+	}
+}
+
+// MergeLayers should wrap across the anti-meridian and leave tiles outside
+// the world empty, as MergeViewLayers does
+func Test_MergeLayers_World(t *testing.T) {
+	view := View{Zoom: 1, X: 256, Y: -100, Width: 512, Height: 300, TileSize: TILE_SIZE}
+	expected, err := MergeViewLayers(context.Background(), []Layer{NewLayer(testTiles("jpg"))}, view, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	src := &countingSource{src: testTiles("jpg")}
+	img, err := MergeLayers(context.Background(), []Layer{NewLayer(src)}, 1, 1, 0, 0, -100, 512, 300, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if diff := maxDifference(img, expected); diff != 0 {
+		t.Errorf("MergeLayers() across the anti-meridian differs from MergeViewLayers() by %v", diff)
+	}
+	expectedRequests := []string{"1/1/0", "1/0/0"}
+	if fmt.Sprint(src.requested) != fmt.Sprint(expectedRequests) {
+		t.Errorf("MergeLayers() requested %v, expected %v", src.requested, expectedRequests)
+	}
+}
@@ -0,0 +1,129 @@
+package tilemerge
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// MarkerShape is the shape of a built-in marker
+type MarkerShape int
+
+const (
+	// Circle is a filled circle centered on the position of the marker
+	Circle MarkerShape = iota
+	// Pin is a map pin with its point at the position of the marker and a
+	// white dot in its head
+	Pin
+)
+
+// Marker is an Overlay that draws a built-in shape or an icon at Lon, Lat
+type Marker struct {
+	Lon, Lat float64
+	Shape    MarkerShape
+	Color    color.Color
+	// Size is the diameter of circles, or the height of pins, in pixels
+	Size float64
+
+	// Icon is drawn instead of the shape, if set, with its Anchor point (in
+	// pixels from the upper left of Icon) at Lon, Lat
+	Icon   image.Image
+	Anchor image.Point
+}
+
+// CircleMarker returns a Marker that draws a circle of diameter size pixels
+// in color c, centered on lon, lat
+func CircleMarker(lon, lat, size float64, c color.Color) Marker {
+	return Marker{Lon: lon, Lat: lat, Shape: Circle, Color: c, Size: size}
+}
+
+// PinMarker returns a Marker that draws a pin of height size pixels in
+// color c, pointing at lon, lat
+func PinMarker(lon, lat, size float64, c color.Color) Marker {
+	return Marker{Lon: lon, Lat: lat, Shape: Pin, Color: c, Size: size}
+}
+
+// IconMarker returns a Marker that draws icon with its anchor point, in
+// pixels from the upper left of icon, at lon, lat.  For example, the anchor
+// of a Leaflet marker icon is at the middle of its bottom edge.
+func IconMarker(lon, lat float64, icon image.Image, anchor image.Point) Marker {
+	return Marker{Lon: lon, Lat: lat, Icon: icon, Anchor: anchor}
+}
+
+// Draw draws the marker on dst
+func (m Marker) Draw(dst draw.Image, pixel func(lon, lat float64) (x, y float64)) {
+	x, y := pixel(m.Lon, m.Lat)
+
+	if m.Icon != nil {
+		// icons are not resampled, so the anchor is moved to the nearest pixel
+		b := m.Icon.Bounds()
+		min := image.Pt(int(math.Floor(x+0.5))-m.Anchor.X, int(math.Floor(y+0.5))-m.Anchor.Y)
+		draw.Draw(dst, b.Sub(b.Min).Add(min), m.Icon, b.Min, draw.Over)
+		return
+	}
+
+	c := m.Color
+	if c == nil {
+		c = color.Black
+	}
+
+	if m.Shape == Pin {
+		// the head is a circle above the point, joined to the point by
+		// lines tangent to the circle
+		r := m.Size / 3
+		cy := y - m.Size + r
+		d := m.Size - r
+		tangent := (d*d - r*r) / d // distance from the point to the tangents along the axis
+		drawShape(dst, c, x-r, y-m.Size, x+r, y, func(px, py float64) bool {
+			dx, dy := px-x, py-cy
+			if dx*dx+dy*dy <= r*r {
+				return true
+			}
+			t := y - py
+			return t >= 0 && t <= tangent && math.Abs(px-x) <= t*r/math.Sqrt(d*d-r*r)
+		})
+		drawCircle(dst, color.White, x, cy, r*0.4)
+		return
+	}
+
+	drawCircle(dst, c, x, y, m.Size/2)
+}
+
+// drawCircle draws a circle of radius r centered on x, y in color c on dst
+func drawCircle(dst draw.Image, c color.Color, x, y, r float64) {
+	drawShape(dst, c, x-r, y-r, x+r, y+r, func(px, py float64) bool {
+		dx, dy := px-x, py-y
+		return dx*dx+dy*dy <= r*r
+	})
+}
+
+// subsamples is the number of points along each side of a pixel that are
+// tested to find how much of the pixel a shape covers
+const subsamples = 4
+
+// drawShape draws the shape within x0, y0, x1, y1 that contains the points
+// for which inside returns true in color c on dst.  Pixels partly covered by
+// the shape are partly transparent, so its edges are anti-aliased.
+func drawShape(dst draw.Image, c color.Color, x0, y0, x1, y1 float64, inside func(x, y float64) bool) {
+	r := image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1))).Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+
+	mask := image.NewAlpha(r)
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			n := 0
+			for sy := 0; sy < subsamples; sy++ {
+				for sx := 0; sx < subsamples; sx++ {
+					if inside(float64(px)+(float64(sx)+0.5)/subsamples, float64(py)+(float64(sy)+0.5)/subsamples) {
+						n++
+					}
+				}
+			}
+			mask.SetAlpha(px, py, color.Alpha{uint8((n*255 + subsamples*subsamples/2) / (subsamples * subsamples))})
+		}
+	}
+	draw.DrawMask(dst, r, image.NewUniform(c), image.ZP, mask, r.Min, draw.Over)
+}
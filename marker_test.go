@@ -0,0 +1,147 @@
+package tilemerge
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	"github.com/brendan-ward/tilemerge/mercator"
+)
+
+var red = color.RGBA{230, 50, 50, 255}
+
+// testIcon returns a 5 x 7 icon with a different color in each pixel
+func testIcon() *image.RGBA {
+	icon := image.NewRGBA(image.Rect(10, 20, 15, 27))
+	for y := 20; y < 27; y++ {
+		for x := 10; x < 15; x++ {
+			icon.SetRGBA(x, y, color.RGBA{uint8(x * 10), uint8(y * 5), 100, 255})
+		}
+	}
+	return icon
+}
+
+func Test_Markers(t *testing.T) {
+	markers := Overlays(
+		CircleMarker(-30, 20, 20, red),
+		PinMarker(20, 40, 36, color.RGBA{40, 80, 200, 255}),
+		IconMarker(60, 10, testIcon(), image.Pt(2, 6)),
+	)
+	img, err := Merge(jpgTiles(), 100, 50, 300, 200, nil, markers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		exportPNG(img, "test_data/output/test_markers.png")
+	}
+
+	verifyDimensions(t, img, 300, 200)
+	verifyPNG(t, img, "test_data/output/test_markers.png")
+
+	rgba := img.(*image.RGBA)
+	pixel := func(lon, lat float64) image.Point {
+		x, y := mercator.Project(lon, lat, 1, TILE_SIZE)
+		return image.Pt(int(math.Floor(x))-100, int(math.Floor(y))-50)
+	}
+
+	if c := rgba.RGBAAt(pixel(-30, 20).X, pixel(-30, 20).Y); c != red {
+		t.Errorf("center of circle marker is %v, expected %v", c, red)
+	}
+
+	// the anchor of the icon is at the nearest pixel edge to lon, lat
+	x, y := mercator.Project(60, 10, 1, TILE_SIZE)
+	min := image.Pt(int(math.Floor(x+0.5))-100-2, int(math.Floor(y+0.5))-50-6)
+	icon := testIcon()
+	for iy := 0; iy < 7; iy++ {
+		for ix := 0; ix < 5; ix++ {
+			expected := icon.RGBAAt(10+ix, 20+iy)
+			if c := rgba.RGBAAt(min.X+ix, min.Y+iy); c != expected {
+				t.Fatalf("icon pixel %v, %v is %v, expected %v", ix, iy, c, expected)
+			}
+		}
+	}
+}
+
+func Test_Markers_Outside(t *testing.T) {
+	// markers outside the image do not change it
+	expected, err := Merge(jpgTiles(), 100, 50, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Merge(jpgTiles(), 100, 50, 300, 200, nil, Overlays(
+		CircleMarker(-170, 0, 20, red),
+		PinMarker(0, -80, 20, red),
+		IconMarker(170, 0, testIcon(), image.ZP),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := maxDifference(img, expected); diff != 0 {
+		t.Errorf("image with markers outside it differs from image without by %v", diff)
+	}
+}
+
+func Test_Markers_View(t *testing.T) {
+	// markers are positioned in fractional zoom levels, scaled and rotated
+	// images as tiles are
+	view, err := ViewportView(10, 20, 1.5, 300, 200, TILE_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range [][]Option{
+		nil,
+		{OutputScale(2, Bilinear)},
+		{Bearing(45)},
+	} {
+		opts = append(opts, Overlays(CircleMarker(10, 20, 6, red)))
+		img, err := MergeView(context.Background(), testTiles("jpg"), view, nil, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the view is centered on the marker
+		b := img.Bounds()
+		if c := img.(*image.RGBA).RGBAAt(b.Dx()/2, b.Dy()/2); c != red {
+			t.Errorf("center of view with options %v is %v, expected %v", len(opts), c, red)
+		}
+	}
+}
+
+func Test_Markers_Paletted(t *testing.T) {
+	img, err := Merge(palettedTiles(testPalette), 100, 50, 300, 200, nil, Overlays(CircleMarker(0, 0, 10, color.RGBA{1, 2, 3, 255})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*image.RGBA); !ok {
+		t.Errorf("Merge() with markers of colors not in the palette returned %T, expected *image.RGBA", img)
+	}
+}
+
+func Test_DrawOverlays(t *testing.T) {
+	// DrawOverlays positions overlays the same as the Overlays option,
+	// including for images that do not start at 0, 0
+	geo, err := TilesGeoreference(jpgTiles(), 100, 50, 300, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := Merge(jpgTiles(), 100, 50, 300, 200, nil, Overlays(PinMarker(20, 40, 36, red)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := Merge(jpgTiles(), 100, 50, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(-10, 5, 290, 205))
+	draw.Draw(img, img.Bounds(), merged, image.ZP, draw.Src)
+	DrawOverlays(img, geo, PinMarker(20, 40, 36, red))
+
+	if diff := maxDifference(toRGBA(img), expected); diff != 0 {
+		t.Errorf("image drawn with DrawOverlays differs from Overlays option by %v", diff)
+	}
+}
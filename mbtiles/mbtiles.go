@@ -0,0 +1,175 @@
+// Package mbtiles provides a tile source backed by an MBTiles (SQLite)
+// database, for use with tilemerge.MergeSource and related functions.
+//
+// See https://github.com/mapbox/mbtiles-spec for the MBTiles specification.
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	_ "image/jpeg" // load jpeg decoder
+	_ "image/png"  // load png decoder
+
+	_ "github.com/mattn/go-sqlite3" // load sqlite3 driver
+	_ "golang.org/x/image/webp"     //load webp decoder
+)
+
+// Metadata holds the contents of the metadata table of an MBTiles database
+type Metadata struct {
+	Name             string
+	Format           string     // tile image format: png, jpg, or webp
+	Bounds           [4]float64 // west, south, east, north; all 0 if not set
+	MinZoom, MaxZoom uint8
+	TileSize         int // from the tilesize entry, or detected from a tile
+}
+
+// MBTiles is a TileSource that reads tiles from an MBTiles database.
+// Tiles are requested in XYZ order; the TMS tile_row stored in the
+// database is flipped automatically.
+type MBTiles struct {
+	db       *sql.DB
+	metadata Metadata
+}
+
+// Open opens the MBTiles database at path for reading, and reads its metadata
+func Open(path string) (*MBTiles, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MBTiles{db: db}
+	if err := m.readMetadata(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close closes the database
+func (m *MBTiles) Close() error {
+	return m.db.Close()
+}
+
+// Metadata returns the metadata read from the database
+func (m *MBTiles) Metadata() Metadata {
+	return m.metadata
+}
+
+// GetTile returns the image data of the tile at z, x, y, or nil if there is
+// no tile at that coordinate
+func (m *MBTiles) GetTile(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+	if z < m.metadata.MinZoom || z > m.metadata.MaxZoom {
+		return nil, nil
+	}
+
+	// MBTiles uses the TMS tiling scheme, with rows numbered from the bottom
+	row := (1 << z) - 1 - y
+
+	var data []byte
+	err := m.db.QueryRowContext(ctx,
+		"SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?",
+		z, x, row).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readMetadata reads the metadata table, falling back to the contents of
+// the tiles table for the zoom range and tile size if they are not present
+func (m *MBTiles) readMetadata() error {
+	rows, err := m.db.Query("SELECT name, value FROM metadata")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		values[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	md := Metadata{
+		Name:   values["name"],
+		Format: values["format"],
+	}
+
+	if bounds, ok := values["bounds"]; ok {
+		parts := strings.Split(bounds, ",")
+		if len(parts) != 4 {
+			return fmt.Errorf("mbtiles: invalid bounds in metadata: %q", bounds)
+		}
+		for i, part := range parts {
+			md.Bounds[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return fmt.Errorf("mbtiles: invalid bounds in metadata: %q", bounds)
+			}
+		}
+	}
+
+	minZoom, hasMin := values["minzoom"]
+	maxZoom, hasMax := values["maxzoom"]
+	if hasMin && hasMax {
+		if md.MinZoom, err = parseZoom(minZoom); err != nil {
+			return err
+		}
+		if md.MaxZoom, err = parseZoom(maxZoom); err != nil {
+			return err
+		}
+	} else {
+		var min, max sql.NullInt64
+		err := m.db.QueryRow("SELECT MIN(zoom_level), MAX(zoom_level) FROM tiles").Scan(&min, &max)
+		if err != nil {
+			return err
+		}
+		md.MinZoom = uint8(min.Int64)
+		md.MaxZoom = uint8(max.Int64)
+	}
+
+	if tileSize, ok := values["tilesize"]; ok {
+		if md.TileSize, err = strconv.Atoi(tileSize); err != nil {
+			return fmt.Errorf("mbtiles: invalid tilesize in metadata: %q", tileSize)
+		}
+	} else {
+		var data []byte
+		err := m.db.QueryRow("SELECT tile_data FROM tiles LIMIT 1").Scan(&data)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if data != nil {
+			config, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			md.TileSize = config.Width
+		}
+	}
+
+	m.metadata = md
+	return nil
+}
+
+func parseZoom(value string) (uint8, error) {
+	zoom, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("mbtiles: invalid zoom in metadata: %q", value)
+	}
+	return uint8(zoom), nil
+}
@@ -0,0 +1,158 @@
+package mbtiles
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/brendan-ward/tilemerge"
+	"github.com/brendan-ward/tilemerge/mercator"
+)
+
+const fixture = "../test_data/test.mbtiles"
+
+var update = flag.Bool("update", false, "update test MBTiles fixture")
+
+var _ tilemerge.TileSource = &MBTiles{}
+
+// createFixture creates an MBTiles database from the PNG tiles in test_data
+func createFixture(path string) {
+	os.Remove(path)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	west, north := mercator.Unproject(2*256, 5*256, 4, 256)
+	east, south := mercator.Unproject(5*256, 7*256, 4, 256)
+
+	statements := []string{
+		"CREATE TABLE metadata (name text, value text)",
+		"CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob)",
+		"CREATE UNIQUE INDEX tile_index on tiles (zoom_level, tile_column, tile_row)",
+		"INSERT INTO metadata VALUES ('name', 'test'), ('format', 'png'), ('minzoom', '4'), ('maxzoom', '4')",
+		fmt.Sprintf("INSERT INTO metadata VALUES ('bounds', '%v,%v,%v,%v')", west, south, east, north),
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			panic(err)
+		}
+	}
+
+	for y := 5; y <= 6; y++ {
+		for x := 2; x <= 4; x++ {
+			_, err := db.Exec("INSERT INTO tiles VALUES (?, ?, ?, ?)", 4, x, 15-y, readTile(4, x, y))
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+var files = tilemerge.NewDirectorySource("../test_data", "{z}_{x}_{y}.png")
+
+// readTile reads a PNG tile from test_data, or returns nil if it does not exist
+func readTile(z uint8, x, y int) []byte {
+	data, err := files.GetTile(context.Background(), z, x, y)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func openFixture() *MBTiles {
+	if *update {
+		createFixture(fixture)
+	}
+
+	m, err := Open(fixture)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func Test_Open_Missing(t *testing.T) {
+	if _, err := Open("../test_data/missing.mbtiles"); err == nil {
+		t.Error("Open() of missing file did not return an error")
+	}
+}
+
+func Test_Metadata(t *testing.T) {
+	m := openFixture()
+	defer m.Close()
+
+	md := m.Metadata()
+	if md.Name != "test" || md.Format != "png" {
+		t.Errorf("Metadata() name, format = %q, %q, expected \"test\", \"png\"", md.Name, md.Format)
+	}
+	if md.MinZoom != 4 || md.MaxZoom != 4 {
+		t.Errorf("Metadata() zoom range = %v - %v, expected 4 - 4", md.MinZoom, md.MaxZoom)
+	}
+	if md.TileSize != 256 {
+		t.Errorf("Metadata() tile size = %v, expected 256", md.TileSize)
+	}
+
+	expected := [4]float64{-135, 21.943046, -67.5, 55.776573}
+	for i := range expected {
+		if math.Abs(md.Bounds[i]-expected[i]) > 1e-6 {
+			t.Errorf("Metadata() bounds = %v, expected %v", md.Bounds, expected)
+			break
+		}
+	}
+}
+
+func Test_GetTile(t *testing.T) {
+	m := openFixture()
+	defer m.Close()
+
+	for y := 4; y <= 7; y++ {
+		for x := 1; x <= 5; x++ {
+			data, err := m.GetTile(context.Background(), 4, x, y)
+			if err != nil {
+				panic(err)
+			}
+
+			if !bytes.Equal(data, readTile(4, x, y)) {
+				t.Errorf("GetTile(4, %v, %v) did not return the expected tile", x, y)
+			}
+		}
+	}
+
+	// outside zoom range
+	data, err := m.GetTile(context.Background(), 3, 1, 2)
+	if err != nil {
+		panic(err)
+	}
+	if data != nil {
+		t.Error("GetTile() outside zoom range returned data")
+	}
+}
+
+// Merging from MBTiles should produce the same output as merging the tiles from disk
+func Test_MergeSource(t *testing.T) {
+	m := openFixture()
+	defer m.Close()
+
+	expected, err := tilemerge.MergeSource(context.Background(), files, 4, 2, 5, 100, 50, 600, 400, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	img, err := tilemerge.MergeSource(context.Background(), m, 4, 2, 5, 100, 50, 600, 400, nil,
+		tilemerge.TileSize(m.Metadata().TileSize))
+	if err != nil {
+		panic(err)
+	}
+
+	if !bytes.Equal(img.(*image.RGBA).Pix, expected.(*image.RGBA).Pix) {
+		t.Error("MergeSource() from MBTiles did not match tiles from disk")
+	}
+}
@@ -0,0 +1,103 @@
+// Package mercator provides conversions between longitude / latitude and
+// pixel and tile coordinates in the spherical Web Mercator projection
+// (EPSG:3857) used by web map tiles.
+package mercator
+
+import "math"
+
+// MaxLatitude is the northernmost latitude that can be projected into the
+// square Web Mercator world; the southernmost latitude is -MaxLatitude.
+const MaxLatitude = 85.0511287798066
+
+// EarthRadius is the radius in meters of the sphere that Web Mercator
+// projects from
+const EarthRadius = 6378137
+
+// HalfWorld is the distance in meters from the center of the world to each
+// of its edges in Web Mercator
+const HalfWorld = math.Pi * EarthRadius
+
+// WorldSize returns the width and height in pixels of the world at zoom,
+// for tiles that are tileSize pixels square
+func WorldSize(zoom float64, tileSize int) float64 {
+	return float64(tileSize) * math.Exp2(zoom)
+}
+
+// Project converts lon, lat into pixel coordinates at zoom, measured from the
+// upper left of the world.  Latitude is clamped to +/- MaxLatitude.
+func Project(lon, lat, zoom float64, tileSize int) (x, y float64) {
+	size := WorldSize(zoom, tileSize)
+	lat = math.Max(math.Min(lat, MaxLatitude), -MaxLatitude)
+	sin := math.Sin(lat * math.Pi / 180)
+
+	x = (lon/360 + 0.5) * size
+	y = (0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)) * size
+	return x, y
+}
+
+// Unproject converts pixel coordinates at zoom into lon, lat
+func Unproject(x, y, zoom float64, tileSize int) (lon, lat float64) {
+	size := WorldSize(zoom, tileSize)
+
+	lon = (x/size - 0.5) * 360
+	lat = 90 - 360*math.Atan(math.Exp((y/size-0.5)*2*math.Pi))/math.Pi
+	return lon, lat
+}
+
+// Meters converts pixel coordinates at zoom into Web Mercator (EPSG:3857)
+// coordinates in meters, which increase to the east and north
+func Meters(x, y, zoom float64, tileSize int) (mx, my float64) {
+	res := 2 * HalfWorld / WorldSize(zoom, tileSize)
+	return x*res - HalfWorld, HalfWorld - y*res
+}
+
+// Pixels converts Web Mercator (EPSG:3857) coordinates in meters into pixel
+// coordinates at zoom
+func Pixels(mx, my, zoom float64, tileSize int) (x, y float64) {
+	res := 2 * HalfWorld / WorldSize(zoom, tileSize)
+	return (mx + HalfWorld) / res, (HalfWorld - my) / res
+}
+
+// TileIndex returns the index of the tile that contains the pixel coordinate
+// p, which may be negative
+func TileIndex(p, tileSize int) int {
+	if p < 0 {
+		return (p+1)/tileSize - 1
+	}
+	return p / tileSize
+}
+
+// WrapX returns the tile column x normalized into the range of columns at
+// zoom, 0 to 2^zoom - 1.  Columns to the west of the anti-meridian or beyond
+// one world width wrap around to the other side of the world.
+func WrapX(x int, zoom uint8) int {
+	n := 1 << zoom
+	x %= n
+	if x < 0 {
+		x += n
+	}
+	return x
+}
+
+// FlipY converts between XYZ and TMS tile rows at zoom; TMS rows are
+// numbered from the bottom of the world rather than the top
+func FlipY(y int, zoom uint8) int {
+	return (1 << zoom) - 1 - y
+}
+
+// Quadkey returns the Bing Maps quadkey of the tile at zoom, x, y
+func Quadkey(zoom uint8, x, y int) string {
+	key := make([]byte, zoom)
+	for i := uint8(0); i < zoom; i++ {
+		mask := 1 << (zoom - i - 1)
+		digit := byte('0')
+		if x&mask != 0 {
+			digit++
+		}
+		if y&mask != 0 {
+			digit += 2
+		}
+		key[i] = digit
+	}
+	return string(key)
+}
@@ -0,0 +1,154 @@
+package mercator
+
+import (
+	"math"
+	"testing"
+)
+
+const tolerance = 1e-6
+
+func Test_Project(t *testing.T) {
+	cases := []struct {
+		lon, lat, zoom float64
+		tileSize       int
+		x, y           float64
+	}{
+		{0, 0, 0, 256, 128, 128},
+		{-180, MaxLatitude, 0, 256, 0, 0},
+		{180, -MaxLatitude, 0, 256, 256, 256},
+		{0, 0, 1, 256, 256, 256},
+		{0, 0, 1, 512, 512, 512},
+		{-180, 90, 2, 256, 0, 0}, // latitude is clamped
+		// Leaflet: map.project([45, -90], 3)
+		{-90, 45, 3, 256, 512, 736.7168756023398},
+	}
+
+	for _, c := range cases {
+		x, y := Project(c.lon, c.lat, c.zoom, c.tileSize)
+		if math.Abs(x-c.x) > tolerance || math.Abs(y-c.y) > tolerance {
+			t.Errorf("Project(%v, %v, %v, %v) = %v, %v, expected %v, %v",
+				c.lon, c.lat, c.zoom, c.tileSize, x, y, c.x, c.y)
+		}
+
+		if c.lat > MaxLatitude || c.lat < -MaxLatitude {
+			continue
+		}
+		lon, lat := Unproject(x, y, c.zoom, c.tileSize)
+		if math.Abs(lon-c.lon) > tolerance || math.Abs(lat-c.lat) > tolerance {
+			t.Errorf("Unproject(%v, %v, %v, %v) = %v, %v, expected %v, %v",
+				x, y, c.zoom, c.tileSize, lon, lat, c.lon, c.lat)
+		}
+	}
+}
+
+func Test_Meters(t *testing.T) {
+	cases := []struct {
+		x, y, zoom float64
+		tileSize   int
+		mx, my     float64
+	}{
+		{0, 0, 0, 256, -HalfWorld, HalfWorld},
+		{128, 128, 0, 256, 0, 0},
+		{512, 512, 1, 512, 0, 0},
+		{1024, 0, 2, 256, HalfWorld, HalfWorld},
+		// gdaltransform -s_srs EPSG:4326 -t_srs EPSG:3857 of -90, 45
+		{512, 736.7168756023398, 3, 256, -10018754.171394622, 5621521.486192066},
+	}
+
+	for _, c := range cases {
+		mx, my := Meters(c.x, c.y, c.zoom, c.tileSize)
+		if math.Abs(mx-c.mx) > tolerance || math.Abs(my-c.my) > tolerance {
+			t.Errorf("Meters(%v, %v, %v, %v) = %v, %v, expected %v, %v",
+				c.x, c.y, c.zoom, c.tileSize, mx, my, c.mx, c.my)
+		}
+
+		x, y := Pixels(mx, my, c.zoom, c.tileSize)
+		if math.Abs(x-c.x) > tolerance || math.Abs(y-c.y) > tolerance {
+			t.Errorf("Pixels(%v, %v, %v, %v) = %v, %v, expected %v, %v",
+				mx, my, c.zoom, c.tileSize, x, y, c.x, c.y)
+		}
+	}
+}
+
+func Test_TileIndex(t *testing.T) {
+	cases := []struct {
+		p, tileSize, index int
+	}{
+		{0, 256, 0},
+		{255, 256, 0},
+		{256, 256, 1},
+		{-1, 256, -1},
+		{-256, 256, -1},
+		{-257, 256, -2},
+	}
+
+	for _, c := range cases {
+		if index := TileIndex(c.p, c.tileSize); index != c.index {
+			t.Errorf("TileIndex(%v, %v) = %v, expected %v", c.p, c.tileSize, index, c.index)
+		}
+	}
+}
+
+func Test_WrapX(t *testing.T) {
+	cases := []struct {
+		x    int
+		zoom uint8
+		wrap int
+	}{
+		{0, 0, 0},
+		{1, 0, 0},
+		{-1, 0, 0},
+		{3, 2, 3},
+		{4, 2, 0},
+		{-1, 2, 3},
+		{-4, 2, 0},
+		{-5, 2, 3},
+		{9, 2, 1},
+	}
+
+	for _, c := range cases {
+		if wrap := WrapX(c.x, c.zoom); wrap != c.wrap {
+			t.Errorf("WrapX(%v, %v) = %v, expected %v", c.x, c.zoom, wrap, c.wrap)
+		}
+	}
+}
+
+func Test_FlipY(t *testing.T) {
+	cases := []struct {
+		y    int
+		zoom uint8
+		flip int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{5, 4, 10},
+		{10, 4, 5},
+	}
+
+	for _, c := range cases {
+		if flip := FlipY(c.y, c.zoom); flip != c.flip {
+			t.Errorf("FlipY(%v, %v) = %v, expected %v", c.y, c.zoom, flip, c.flip)
+		}
+	}
+}
+
+// Quadkeys are from https://docs.microsoft.com/en-us/bingmaps/articles/bing-maps-tile-system
+func Test_Quadkey(t *testing.T) {
+	cases := []struct {
+		zoom uint8
+		x, y int
+		key  string
+	}{
+		{0, 0, 0, ""},
+		{1, 1, 0, "1"},
+		{1, 0, 1, "2"},
+		{3, 3, 5, "213"},
+		{4, 2, 5, "0212"},
+	}
+
+	for _, c := range cases {
+		if key := Quadkey(c.zoom, c.x, c.y); key != c.key {
+			t.Errorf("Quadkey(%v, %v, %v) = %q, expected %q", c.zoom, c.x, c.y, key, c.key)
+		}
+	}
+}
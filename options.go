@@ -0,0 +1,211 @@
+package tilemerge
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Option sets optional behavior of Merge
+type Option func(*options)
+
+type options struct {
+	tileSize int
+	workers  int
+	op       draw.Op
+	overzoom int
+
+	underzoom       bool
+	underzoomFilter Filter
+
+	scale  float64
+	filter Filter
+
+	tileZoom ZoomRounding
+
+	bearing float64
+
+	quantizer Quantizer
+	colors    int
+
+	overlays []Overlay
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		tileSize: TILE_SIZE,
+		workers:  1,
+		op:       draw.Src,
+		scale:    1,
+		filter:   Bilinear,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// TileSize sets the width and height in pixels of the input tiles, for
+// example 512 for vector-rendered or @2x tiles.
+// Use AutoTileSize to detect the size from the first decoded tile.
+// Merging returns an error for any other size that is not greater than 0.
+func TileSize(size int) Option {
+	return func(o *options) {
+		o.tileSize = size
+	}
+}
+
+// Workers sets the number of tiles that are requested, decoded, and drawn at
+// once.  The merged image is the same regardless of the number of workers.
+// The TileSource must be safe for concurrent use if n is greater than 1.
+func Workers(n int) Option {
+	return func(o *options) {
+		o.workers = n
+	}
+}
+
+// Composite sets how tiles are drawn over the background color.
+// With draw.Src (the default), tiles replace the background, including in
+// their transparent areas.  With draw.Over, transparent and partially
+// transparent areas of tiles are composited over the background.
+func Composite(op draw.Op) Option {
+	return func(o *options) {
+		o.op = op
+	}
+}
+
+// Overzoom fills tiles that are missing from the TileSource by scaling up the
+// part of the nearest ancestor tile that covers them, from up to levels zoom
+// levels above.  Ancestors are requested from the same TileSource.
+func Overzoom(levels int) Option {
+	return func(o *options) {
+		o.overzoom = levels
+	}
+}
+
+// Underzoom fills tiles that are missing from the TileSource by scaling down
+// their four child tiles from one zoom level below, using filter.
+// Children are requested from the same TileSource, and are used before any
+// ancestor allowed by Overzoom.
+func Underzoom(filter Filter) Option {
+	return func(o *options) {
+		o.underzoom = true
+		o.underzoomFilter = filter
+	}
+}
+
+// OutputScale scales the merged image by scale, for example 1.5 or 0.5,
+// resampling it with filter.  Offsets, widths and heights passed to Merge are
+// still in pixels of the tiles; the merged image is their width and height
+// times scale, rounded to the nearest pixel.  Merging returns an error for a
+// scale that is not a finite number greater than 0.
+// The filter is also used to scale views with fractional zoom levels.
+func OutputScale(scale float64, filter Filter) Option {
+	return func(o *options) {
+		o.scale = scale
+		o.filter = filter
+	}
+}
+
+// Bearing rotates merged images about their center so that their top faces
+// degrees clockwise from north, as a map rotated to that bearing shows it.
+// Enough extra tiles are merged to fill the corners of the rotated image,
+// which is resampled with the filter set by OutputScale.
+// Merge can only draw the tiles it is given, so they must cover the rotated
+// image; the other merge functions request the extra tiles from their source.
+// Merging returns an error for a bearing that is not a finite number.
+func Bearing(degrees float64) Option {
+	return func(o *options) {
+		o.bearing = degrees
+	}
+}
+
+// ZoomRounding sets how fractional zoom levels are rounded to the zoom level
+// of the tiles used to render them
+type ZoomRounding int
+
+const (
+	// RoundZoom uses tiles from the nearest zoom level, as Leaflet does
+	RoundZoom ZoomRounding = iota
+	// FloorZoom uses tiles from the zoom level below, scaled up
+	FloorZoom
+	// CeilZoom uses tiles from the zoom level above, scaled down; this
+	// requests more tiles, but keeps more detail
+	CeilZoom
+)
+
+// round returns the tile zoom level for zoom
+func (r ZoomRounding) round(zoom float64) float64 {
+	switch r {
+	case FloorZoom:
+		return math.Floor(zoom)
+	case CeilZoom:
+		return math.Ceil(zoom)
+	}
+	return math.Floor(zoom + 0.5)
+}
+
+// TileZoom sets how fractional zoom levels of views are rounded to the zoom
+// level of the tiles that are merged.  Tiles are scaled by 2^(zoom - tile
+// zoom) to fit, using the filter set by OutputScale.
+func TileZoom(r ZoomRounding) Option {
+	return func(o *options) {
+		o.tileZoom = r
+	}
+}
+
+// Quantize reduces merged images to a palette of up to colors colors (at
+// most 256) chosen by q, and returns them as *image.Paletted.  Pixels are
+// drawn with the nearest color of the palette, without dithering.
+// Merged images whose tiles all share a palette of up to colors colors keep
+// that palette.
+func Quantize(q Quantizer, colors int) Option {
+	return func(o *options) {
+		o.quantizer = q
+		o.colors = colors
+	}
+}
+
+// Overlays draws overlays over merged images, in order, after they are
+// scaled and rotated and before they are quantized.  Overlays are positioned
+// with the same zoom level, tile range and offsets as the merged image, but
+// their sizes are in pixels of the output, so they are not scaled by
+// OutputScale.
+func Overlays(overlays ...Overlay) Option {
+	return func(o *options) {
+		o.overlays = append(o.overlays, overlays...)
+	}
+}
+
+// validate returns an error if any option set by o is invalid
+func (o *options) validate() error {
+	if o.tileSize < 0 {
+		return errors.New("tilemerge: tile size must be greater than 0, or AutoTileSize")
+	}
+	if !(o.scale > 0) || math.IsInf(o.scale, 1) {
+		return errors.New("tilemerge: output scale must be a finite number greater than 0")
+	}
+	if math.IsNaN(o.bearing) || math.IsInf(o.bearing, 0) {
+		return errors.New("tilemerge: bearing must be a finite number")
+	}
+	return nil
+}
+
+// output returns the merged image img, quantized if set by o
+func (o *options) output(img image.Image) image.Image {
+	if o.colors <= 0 {
+		return img
+	}
+	if paletted, ok := img.(*image.Paletted); ok && len(paletted.Palette) <= o.colors {
+		return img
+	}
+	return quantize(img, o.quantizer, o.colors)
+}
+
+// scaledArea returns the area of width by height pixels with its upper left
+// corner at pixel x, y, in pixels scaled by o
+func (o *options) scaledArea(x, y, width, height int) (sx, sy float64, sw, sh int) {
+	return float64(x) * o.scale, float64(y) * o.scale,
+		int(math.Floor(float64(width)*o.scale + 0.5)), int(math.Floor(float64(height)*o.scale + 0.5))
+}
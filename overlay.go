@@ -0,0 +1,39 @@
+package tilemerge
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Overlay is drawn over merged images at longitude / latitude positions,
+// such as a Marker, Polyline or Polygon
+type Overlay interface {
+	// Draw draws the overlay on dst.  pixel converts lon, lat (in degrees)
+	// into pixel coordinates of dst, where pixel centers are at half pixels.
+	Draw(dst draw.Image, pixel func(lon, lat float64) (x, y float64))
+}
+
+// DrawOverlays draws overlays on img, georeferenced by geo, in order
+func DrawOverlays(img draw.Image, geo Georeference, overlays ...Overlay) {
+	var merc WebMercator
+	b := img.Bounds()
+	pixel := func(lon, lat float64) (x, y float64) {
+		x, y = geo.pixel(merc.Project(lon, lat))
+		return x + float64(b.Min.X), y + float64(b.Min.Y)
+	}
+	for _, overlay := range overlays {
+		overlay.Draw(img, pixel)
+	}
+}
+
+// drawOverlays returns img with the overlays set by o drawn on it, where img
+// is georeferenced by geo.  Paletted images are drawn as *image.RGBA, since
+// overlays may not be in their palette.
+func (o *options) drawOverlays(img image.Image, geo Georeference) image.Image {
+	if len(o.overlays) == 0 {
+		return img
+	}
+	rgba := toRGBA(img)
+	DrawOverlays(rgba, geo, o.overlays...)
+	return rgba
+}
@@ -0,0 +1,341 @@
+package tilemerge
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/vector"
+)
+
+// Stroke sets how lines and the outlines of polygons are drawn.  Lines have
+// round caps and joins, as Leaflet draws them.
+type Stroke struct {
+	Color        color.Color // not drawn if nil
+	Width        float64     // in pixels
+	Transparency float64     // from 0 (opaque) to 1 (transparent)
+
+	// Dashes are the lengths in pixels of alternating dashes and gaps along
+	// the line, starting with a dash.  Lines are solid if Dashes is empty.
+	Dashes []float64
+}
+
+// NewStroke returns an opaque, solid Stroke of width pixels in color c
+func NewStroke(c color.Color, width float64) Stroke {
+	return Stroke{Color: c, Width: width}
+}
+
+// Polyline is an Overlay that draws a line through Points
+type Polyline struct {
+	Points [][2]float64 // longitude, latitude pairs, as in GeoJSON
+	Stroke Stroke
+}
+
+// NewPolyline returns a Polyline through points drawn with stroke
+func NewPolyline(points [][2]float64, stroke Stroke) Polyline {
+	return Polyline{Points: points, Stroke: stroke}
+}
+
+// Draw draws the line on dst
+func (p Polyline) Draw(dst draw.Image, pixel func(lon, lat float64) (x, y float64)) {
+	drawStroke(dst, [][]f64.Vec2{projectPoints(p.Points, pixel)}, false, p.Stroke)
+}
+
+// Polygon is an Overlay that fills an area and draws its outline
+type Polygon struct {
+	// Rings are rings of longitude, latitude pairs, as in GeoJSON: the first
+	// is the exterior of the polygon, and the rest are holes in it.  Rings
+	// are closed whether or not their last point repeats their first, and
+	// may be in either direction.
+	Rings [][][2]float64
+
+	Fill             color.Color // not filled if nil
+	FillTransparency float64     // from 0 (opaque) to 1 (transparent)
+	Stroke           Stroke
+}
+
+// NewPolygon returns a Polygon of rings filled with opaque color fill and
+// outlined with stroke
+func NewPolygon(rings [][][2]float64, fill color.Color, stroke Stroke) Polygon {
+	return Polygon{Rings: rings, Fill: fill, Stroke: stroke}
+}
+
+// Draw fills the polygon and draws its outline on dst
+func (p Polygon) Draw(dst draw.Image, pixel func(lon, lat float64) (x, y float64)) {
+	rings := make([][]f64.Vec2, len(p.Rings))
+	for i, ring := range p.Rings {
+		rings[i] = projectPoints(ring, pixel)
+	}
+
+	if r := overlayBounds(dst, rings, 0); p.Fill != nil && !r.Empty() {
+		z := vector.NewRasterizer(r.Dx(), r.Dy())
+		pen := pen{z, r.Min}
+		for i, ring := range rings {
+			if len(ring) < 3 {
+				continue
+			}
+			// holes wind the other way from the exterior, so the rasterizer
+			// subtracts them from it
+			if (i == 0) != (signedArea(ring) > 0) {
+				ring = reversed(ring)
+			}
+			pen.moveTo(ring[0])
+			for _, pt := range ring[1:] {
+				pen.lineTo(pt)
+			}
+			z.ClosePath()
+		}
+		z.Draw(dst, r, overlaySource(p.Fill, 1-p.FillTransparency), image.ZP)
+	}
+
+	drawStroke(dst, rings, true, p.Stroke)
+}
+
+// projectPoints converts lon, lat points into pixel coordinates with pixel
+func projectPoints(points [][2]float64, pixel func(lon, lat float64) (x, y float64)) []f64.Vec2 {
+	projected := make([]f64.Vec2, len(points))
+	for i, p := range points {
+		x, y := pixel(p[0], p[1])
+		projected[i] = f64.Vec2{x, y}
+	}
+	return projected
+}
+
+// signedArea returns the area of ring, which is positive if it runs
+// clockwise in pixel coordinates (where y increases downwards)
+func signedArea(ring []f64.Vec2) float64 {
+	area := 0.0
+	for i, p := range ring {
+		q := ring[(i+1)%len(ring)]
+		area += p[0]*q[1] - q[0]*p[1]
+	}
+	return area / 2
+}
+
+// reversed returns a copy of points in reverse order
+func reversed(points []f64.Vec2) []f64.Vec2 {
+	r := make([]f64.Vec2, len(points))
+	for i, p := range points {
+		r[len(points)-1-i] = p
+	}
+	return r
+}
+
+// overlayBounds returns the part of dst within pad pixels of the points of
+// paths, which is empty if no part of dst is that close
+func overlayBounds(dst draw.Image, paths [][]f64.Vec2, pad float64) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, path := range paths {
+		for _, p := range path {
+			minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+			minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+		}
+	}
+	if minX > maxX {
+		return image.Rectangle{}
+	}
+	return pixelBounds(minX-pad, minY-pad, maxX+pad, maxY+pad, dst.Bounds())
+}
+
+// pixelBounds returns the pixels of b that are at least partly within x0,
+// y0, x1, y1.  Coordinates far outside b are clamped to keep them within
+// int range.
+func pixelBounds(x0, y0, x1, y1 float64, b image.Rectangle) image.Rectangle {
+	clamp := func(v float64, min, max int) int {
+		return int(math.Max(float64(min-1), math.Min(float64(max+1), v)))
+	}
+	return image.Rect(
+		clamp(math.Floor(x0), b.Min.X, b.Max.X), clamp(math.Floor(y0), b.Min.Y, b.Max.Y),
+		clamp(math.Ceil(x1), b.Min.X, b.Max.X), clamp(math.Ceil(y1), b.Min.Y, b.Max.Y),
+	).Intersect(b)
+}
+
+// overlaySource returns an image of color c at opacity
+func overlaySource(c color.Color, opacity float64) *image.Uniform {
+	opacity = math.Max(0, math.Min(1, opacity))
+	r, g, b, a := c.RGBA()
+	return image.NewUniform(color.RGBA64{
+		uint16(float64(r)*opacity + 0.5),
+		uint16(float64(g)*opacity + 0.5),
+		uint16(float64(b)*opacity + 0.5),
+		uint16(float64(a)*opacity + 0.5),
+	})
+}
+
+// pen adds paths to a rasterizer that covers the pixels of an image from min
+type pen struct {
+	z   *vector.Rasterizer
+	min image.Point
+}
+
+func (p pen) moveTo(a f64.Vec2) {
+	p.z.MoveTo(float32(a[0]-float64(p.min.X)), float32(a[1]-float64(p.min.Y)))
+}
+
+func (p pen) lineTo(a f64.Vec2) {
+	p.z.LineTo(float32(a[0]-float64(p.min.X)), float32(a[1]-float64(p.min.Y)))
+}
+
+func (p pen) cubeTo(a, b, c f64.Vec2) {
+	x, y := float64(p.min.X), float64(p.min.Y)
+	p.z.CubeTo(float32(a[0]-x), float32(a[1]-y), float32(b[0]-x), float32(b[1]-y), float32(c[0]-x), float32(c[1]-y))
+}
+
+// drawStroke draws lines through each of paths with stroke on dst.  Closed
+// paths are joined from their last point back to their first.
+func drawStroke(dst draw.Image, paths [][]f64.Vec2, closed bool, stroke Stroke) {
+	if stroke.Color == nil || stroke.Width <= 0 {
+		return
+	}
+	radius := stroke.Width / 2
+
+	r := overlayBounds(dst, paths, radius)
+	if r.Empty() {
+		return
+	}
+	m := strokeMask{mask: image.NewAlpha(r)}
+	for _, path := range paths {
+		if closed && len(path) > 0 {
+			path = append(path[:len(path):len(path)], path[0])
+		}
+		for _, line := range dashes(path, stroke.Dashes) {
+			m.line(line, radius)
+		}
+	}
+	draw.DrawMask(dst, r, overlaySource(stroke.Color, 1-stroke.Transparency), image.ZP, m.mask, r.Min, draw.Over)
+}
+
+// strokeMask is the coverage of lines, built from a rectangle for each
+// segment and a circle for each point, which make round caps and joins.
+// The rasterizer adds the coverage of overlapping shapes, which would darken
+// the edges where they meet, so each shape is rasterized on its own and
+// combined with the others by taking the greatest coverage of each pixel.
+type strokeMask struct {
+	mask *image.Alpha
+	z    vector.Rasterizer
+	buf  []uint8
+}
+
+// line adds a line r pixels either side of points
+func (m *strokeMask) line(points []f64.Vec2, r float64) {
+	for i, p := range points {
+		m.circle(p, r)
+		if i == 0 {
+			continue
+		}
+
+		a := points[i-1]
+		dx, dy := p[0]-a[0], p[1]-a[1]
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -dy/length*r, dx/length*r
+		corners := []f64.Vec2{
+			{a[0] + nx, a[1] + ny}, {p[0] + nx, p[1] + ny},
+			{p[0] - nx, p[1] - ny}, {a[0] - nx, a[1] - ny},
+		}
+		bounds := overlayBounds(m.mask, [][]f64.Vec2{corners}, 0)
+		m.add(bounds, func(pen pen) {
+			pen.moveTo(corners[0])
+			for _, c := range corners[1:] {
+				pen.lineTo(c)
+			}
+		})
+	}
+}
+
+// kappa is the distance of the control points of a cubic Bézier curve that
+// approximates a quarter circle, as a fraction of its radius
+const kappa = 0.5522847498
+
+// circle adds a circle of radius r centered on p
+func (m *strokeMask) circle(p f64.Vec2, r float64) {
+	x, y, k := p[0], p[1], kappa*r
+	m.add(pixelBounds(x-r, y-r, x+r, y+r, m.mask.Rect), func(pen pen) {
+		pen.moveTo(f64.Vec2{x + r, y})
+		pen.cubeTo(f64.Vec2{x + r, y + k}, f64.Vec2{x + k, y + r}, f64.Vec2{x, y + r})
+		pen.cubeTo(f64.Vec2{x - k, y + r}, f64.Vec2{x - r, y + k}, f64.Vec2{x - r, y})
+		pen.cubeTo(f64.Vec2{x - r, y - k}, f64.Vec2{x - k, y - r}, f64.Vec2{x, y - r})
+		pen.cubeTo(f64.Vec2{x + k, y - r}, f64.Vec2{x + r, y - k}, f64.Vec2{x + r, y})
+	})
+}
+
+// add rasterizes the shape drawn by path within r, and adds it to the mask
+func (m *strokeMask) add(r image.Rectangle, path func(pen pen)) {
+	if r.Empty() {
+		return
+	}
+	w, h := r.Dx(), r.Dy()
+	m.z.Reset(w, h)
+	path(pen{&m.z, r.Min})
+	m.z.ClosePath()
+
+	if cap(m.buf) < w*h {
+		m.buf = make([]uint8, w*h)
+	}
+	shape := &image.Alpha{Pix: m.buf[:w*h], Stride: w, Rect: r}
+	m.z.DrawOp = draw.Src
+	m.z.Draw(shape, r, image.Opaque, image.ZP)
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := m.mask.Pix[m.mask.PixOffset(r.Min.X, y):]
+		for x, a := range shape.Pix[shape.PixOffset(r.Min.X, y):][:w] {
+			if a > row[x] {
+				row[x] = a
+			}
+		}
+	}
+}
+
+// dashes splits the line through points into the dashes of pattern, or
+// returns it whole if pattern does not have any dashes.  As in SVG, patterns
+// of odd length are repeated to make them even.
+func dashes(points []f64.Vec2, pattern []float64) [][]f64.Vec2 {
+	total := 0.0
+	for _, length := range pattern {
+		if length < 0 {
+			return [][]f64.Vec2{points}
+		}
+		total += length
+	}
+	if total == 0 || len(points) < 2 {
+		return [][]f64.Vec2{points}
+	}
+	if len(pattern)%2 == 1 {
+		pattern = append(pattern[:len(pattern):len(pattern)], pattern...)
+	}
+
+	// even indexes of pattern are dashes, and odd are gaps
+	var lines [][]f64.Vec2
+	line := []f64.Vec2{points[0]}
+	i, remaining := 0, pattern[0] // index in pattern, and length left of it
+	for j := 1; j < len(points); j++ {
+		a, b := points[j-1], points[j]
+		length := math.Hypot(b[0]-a[0], b[1]-a[1])
+		t := 0.0
+		for length-t > remaining {
+			t += remaining
+			p := f64.Vec2{a[0] + (b[0]-a[0])*t/length, a[1] + (b[1]-a[1])*t/length}
+			if i%2 == 0 {
+				lines = append(lines, append(line, p))
+				line = nil
+			} else {
+				line = []f64.Vec2{p}
+			}
+			i = (i + 1) % len(pattern)
+			remaining = pattern[i]
+		}
+		remaining -= length - t
+		if i%2 == 0 {
+			line = append(line, b)
+		}
+	}
+	if i%2 == 0 && len(line) > 1 {
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,238 @@
+package tilemerge
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/brendan-ward/tilemerge/mercator"
+	"golang.org/x/image/math/f64"
+)
+
+var (
+	blue  = color.RGBA{40, 80, 200, 255}
+	green = color.RGBA{30, 160, 60, 255}
+)
+
+// testRings returns a square with a square hole, in the directions given
+func testRings(exteriorClockwise, holeClockwise bool) [][][2]float64 {
+	exterior := [][2]float64{{-80, 20}, {-80, 60}, {-20, 60}, {-20, 20}, {-80, 20}}
+	hole := [][2]float64{{-60, 30}, {-60, 50}, {-40, 50}, {-40, 30}}
+	if !exteriorClockwise {
+		exterior = reversedPoints(exterior)
+	}
+	if !holeClockwise {
+		hole = reversedPoints(hole)
+	}
+	return [][][2]float64{exterior, hole}
+}
+
+func reversedPoints(points [][2]float64) [][2]float64 {
+	r := make([][2]float64, len(points))
+	for i, p := range points {
+		r[len(points)-1-i] = p
+	}
+	return r
+}
+
+func testVectorOverlays() Option {
+	polygon := NewPolygon(testRings(true, false), red, NewStroke(blue, 2))
+	polygon.FillTransparency = 0.5
+
+	dashed := NewStroke(green, 6)
+	dashed.Dashes = []float64{20, 10}
+
+	track := NewStroke(blue, 3)
+	track.Transparency = 0.3
+
+	return Overlays(
+		polygon,
+		NewPolyline([][2]float64{{-10, 15}, {90, 15}}, dashed),
+		NewPolyline([][2]float64{{-100, 70}, {-30, 10}, {0, 40}, {60, 30}}, track),
+	)
+}
+
+// testPixel returns the pixel of lon, lat in images merged from jpgTiles at
+// 100, 50
+func testPixel(lon, lat float64) (x, y float64) {
+	x, y = mercator.Project(lon, lat, 1, TILE_SIZE)
+	return x - 100, y - 50
+}
+
+func Test_VectorOverlays(t *testing.T) {
+	base, err := Merge(jpgTiles(), 100, 50, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Merge(jpgTiles(), 100, 50, 300, 200, nil, testVectorOverlays())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		exportPNG(img, "test_data/output/test_vector_overlays.png")
+	}
+
+	verifyDimensions(t, img, 300, 200)
+	verifyPNG(t, img, "test_data/output/test_vector_overlays.png")
+
+	rgba := img.(*image.RGBA)
+	baseRGBA := base.(*image.RGBA)
+	at := func(img *image.RGBA, x, y float64) color.RGBA {
+		return img.RGBAAt(int(math.Floor(x)), int(math.Floor(y)))
+	}
+
+	// the hole is not filled
+	x, y := testPixel(-50, 40)
+	if c, expected := at(rgba, x, y), at(baseRGBA, x, y); c != expected {
+		t.Errorf("pixel in hole is %v, expected %v", c, expected)
+	}
+
+	// the fill is blended with the image at half opacity
+	x, y = testPixel(-70, 25)
+	c, b := at(rgba, x, y), at(baseRGBA, x, y)
+	expected := []uint8{red.R/2 + b.R/2, red.G/2 + b.G/2, red.B/2 + b.B/2}
+	for i, v := range []uint8{c.R, c.G, c.B} {
+		if math.Abs(float64(v)-float64(expected[i])) > 1 {
+			t.Errorf("filled pixel is %v, expected about %v", c, expected)
+			break
+		}
+	}
+
+	// dashes are opaque, and gaps between them are not drawn
+	x, y = testPixel(-10, 15)
+	if c := at(rgba, x+10, y); c != green {
+		t.Errorf("pixel in dash is %v, expected %v", c, green)
+	}
+	if c, expected := at(rgba, x+25, y), at(baseRGBA, x+25, y); c != expected {
+		t.Errorf("pixel in gap between dashes is %v, expected %v", c, expected)
+	}
+
+	// edges of lines are anti-aliased
+	c, b = at(rgba, x+10, y-3), at(baseRGBA, x+10, y-3)
+	if c == green || c == b {
+		t.Errorf("pixel on edge of line is %v, expected between %v and %v", c, green, b)
+	}
+}
+
+func Test_Polygon_Direction(t *testing.T) {
+	// rings are filled the same whichever direction they run in
+	draw := func(exteriorClockwise, holeClockwise bool) *image.RGBA {
+		img, err := Merge(jpgTiles(), 100, 50, 300, 200, nil, Overlays(
+			NewPolygon(testRings(exteriorClockwise, holeClockwise), red, NewStroke(blue, 2)),
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img.(*image.RGBA)
+	}
+
+	expected := draw(true, false)
+	for _, dirs := range [][2]bool{{true, true}, {false, false}, {false, true}} {
+		if diff := maxDifference(draw(dirs[0], dirs[1]), expected); diff != 0 {
+			t.Errorf("polygon with rings clockwise %v differs by %v", dirs, diff)
+		}
+	}
+}
+
+func Test_VectorOverlays_Zero_Value(t *testing.T) {
+	// literals are drawn the same as overlays from NewStroke and NewPolygon
+	draw := func(overlay Overlay) *image.RGBA {
+		img, err := Merge(jpgTiles(), 100, 50, 300, 200, nil, Overlays(overlay))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img.(*image.RGBA)
+	}
+
+	expected := draw(NewPolygon(testRings(true, false), red, NewStroke(blue, 2)))
+	img := draw(Polygon{Rings: testRings(true, false), Fill: red, Stroke: Stroke{Color: blue, Width: 2}})
+	if diff := maxDifference(img, expected); diff != 0 {
+		t.Errorf("Polygon literal differs from NewPolygon() by %v", diff)
+	}
+}
+
+func Test_VectorOverlays_Outside(t *testing.T) {
+	// lines and polygons outside the image, or with points far outside it,
+	// do not change it
+	expected, err := Merge(jpgTiles(), 100, 50, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Merge(jpgTiles(), 100, 50, 300, 200, nil, Overlays(
+		NewPolyline([][2]float64{{-170, -80}, {170, -80}}, NewStroke(red, 4)),
+		NewPolyline([][2]float64{{-120, 89.9999}, {-179.9, 89.9999}}, NewStroke(red, 4)),
+		NewPolygon([][][2]float64{{{120, 0}, {170, 0}, {170, 50}}}, red, NewStroke(blue, 2)),
+		NewPolygon([][][2]float64{{{120, 0}}, nil}, red, NewStroke(blue, 2)),
+		NewPolyline(nil, NewStroke(red, 4)),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := maxDifference(img, expected); diff != 0 {
+		t.Errorf("image with overlays outside it differs from image without by %v", diff)
+	}
+}
+
+func Test_dashes(t *testing.T) {
+	cases := []struct {
+		points   []f64.Vec2
+		pattern  []float64
+		expected [][]f64.Vec2
+	}{
+		{
+			[]f64.Vec2{{0, 0}, {50, 0}},
+			nil,
+			[][]f64.Vec2{{{0, 0}, {50, 0}}},
+		},
+		{
+			[]f64.Vec2{{0, 0}, {50, 0}},
+			[]float64{20, 10},
+			[][]f64.Vec2{{{0, 0}, {20, 0}}, {{30, 0}, {50, 0}}},
+		},
+		{
+			// dashes continue around corners
+			[]f64.Vec2{{0, 0}, {15, 0}, {15, 30}},
+			[]float64{20, 10},
+			[][]f64.Vec2{{{0, 0}, {15, 0}, {15, 5}}, {{15, 15}, {15, 30}}},
+		},
+		{
+			// odd patterns are repeated
+			[]f64.Vec2{{0, 0}, {0, 50}},
+			[]float64{10},
+			[][]f64.Vec2{{{0, 0}, {0, 10}}, {{0, 20}, {0, 30}}, {{0, 40}, {0, 50}}},
+		},
+		{
+			// dashes of zero length are dots
+			[]f64.Vec2{{0, 0}, {25, 0}},
+			[]float64{0, 10},
+			[][]f64.Vec2{{{0, 0}, {0, 0}}, {{10, 0}, {10, 0}}, {{20, 0}, {20, 0}}},
+		},
+		{
+			// patterns without any length are solid
+			[]f64.Vec2{{0, 0}, {50, 0}},
+			[]float64{0, 0},
+			[][]f64.Vec2{{{0, 0}, {50, 0}}},
+		},
+	}
+
+	for _, c := range cases {
+		lines := dashes(c.points, c.pattern)
+		if len(lines) != len(c.expected) {
+			t.Errorf("dashes(%v, %v) returned %v, expected %v", c.points, c.pattern, lines, c.expected)
+			continue
+		}
+		for i, line := range lines {
+			if len(line) != len(c.expected[i]) {
+				t.Errorf("dashes(%v, %v) returned %v, expected %v", c.points, c.pattern, lines, c.expected)
+				break
+			}
+			for j, p := range line {
+				if math.Abs(p[0]-c.expected[i][j][0]) > 1e-9 || math.Abs(p[1]-c.expected[i][j][1]) > 1e-9 {
+					t.Errorf("dashes(%v, %v) returned %v, expected %v", c.points, c.pattern, lines, c.expected)
+				}
+			}
+		}
+	}
+}
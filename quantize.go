@@ -0,0 +1,345 @@
+package tilemerge
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+	"sync"
+)
+
+// Quantizer chooses a palette of colors for an image.  It implements
+// draw.Quantizer, so it can also be used with gif.Options.
+type Quantizer int
+
+const (
+	// MedianCut repeatedly splits the colors of the image into two boxes of
+	// about the same number of pixels, across the channel with the widest
+	// range of values
+	MedianCut Quantizer = iota
+	// Octree merges similar colors from the bottom of a tree of colors
+	// indexed by the bits of each channel, starting with the least common
+	// colors.  It is faster than MedianCut, but less accurate.
+	Octree
+)
+
+// Quantize appends up to cap(p) - len(p) colors chosen for m to p, and
+// returns the updated palette.  Images with that many colors or fewer
+// keep their exact colors.
+func (q Quantizer) Quantize(p color.Palette, m image.Image) color.Palette {
+	n := cap(p) - len(p)
+	if n <= 0 {
+		return p
+	}
+
+	colors := histogram(m)
+	var chosen []color.RGBA
+	if q == Octree {
+		chosen = octreePalette(colors, n)
+	} else {
+		chosen = medianCutPalette(colors, n)
+	}
+
+	for _, c := range chosen {
+		p = append(p, c)
+	}
+	return p
+}
+
+// colorCount is a color and the number of pixels of that color
+type colorCount struct {
+	c [4]uint8 // premultiplied red, green, blue and alpha
+	n int
+}
+
+// histogram returns the number of pixels of each color of img, in order of
+// the colors
+func histogram(img image.Image) []colorCount {
+	counts := make(map[uint32]int)
+	rgba := toRGBA(img)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		p := rgba.Pix[i : i+4 : i+4]
+		counts[uint32(p[0])<<24|uint32(p[1])<<16|uint32(p[2])<<8|uint32(p[3])]++
+	}
+
+	keys := make([]uint32, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	colors := make([]colorCount, len(keys))
+	for i, k := range keys {
+		colors[i] = colorCount{
+			c: [4]uint8{uint8(k >> 24), uint8(k >> 16), uint8(k >> 8), uint8(k)},
+			n: counts[k],
+		}
+	}
+	return colors
+}
+
+// toRGBA returns img as an *image.RGBA with its upper left corner at 0, 0,
+// or img if it already is one
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok && b.Min == image.ZP {
+		return rgba
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// mean returns the mean color of colors, weighted by their counts
+func mean(colors []colorCount) color.RGBA {
+	var sum [4]int
+	n := 0
+	for _, c := range colors {
+		for i := range sum {
+			sum[i] += int(c.c[i]) * c.n
+		}
+		n += c.n
+	}
+	return color.RGBA{
+		uint8((sum[0] + n/2) / n),
+		uint8((sum[1] + n/2) / n),
+		uint8((sum[2] + n/2) / n),
+		uint8((sum[3] + n/2) / n),
+	}
+}
+
+// medianCutPalette returns up to n colors chosen from colors by median cut
+func medianCutPalette(colors []colorCount, n int) []color.RGBA {
+	if len(colors) == 0 {
+		return nil
+	}
+	boxes := [][]colorCount{colors}
+	for len(boxes) < n {
+		// split the box with the widest range of values in any channel
+		best, bestChannel, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			channel, r := widestChannel(box)
+			if r > bestRange {
+				best, bestChannel, bestRange = i, channel, r
+			}
+		}
+		if best < 0 {
+			// every box has a single color
+			break
+		}
+
+		box := boxes[best]
+		sort.SliceStable(box, func(i, j int) bool { return box[i].c[bestChannel] < box[j].c[bestChannel] })
+
+		// split at the median pixel, keeping at least one color on each side
+		total := 0
+		for _, c := range box {
+			total += c.n
+		}
+		k, count := 1, box[0].n
+		for k < len(box)-1 && count+box[k].n <= total/2 {
+			count += box[k].n
+			k++
+		}
+		boxes[best] = box[:k]
+		boxes = append(boxes, box[k:])
+	}
+
+	palette := make([]color.RGBA, len(boxes))
+	for i, box := range boxes {
+		palette[i] = mean(box)
+	}
+	return palette
+}
+
+// widestChannel returns the channel of colors with the widest range of
+// values, and that range
+func widestChannel(colors []colorCount) (channel, r int) {
+	lo := [4]uint8{255, 255, 255, 255}
+	var hi [4]uint8
+	for _, c := range colors {
+		for i, v := range c.c {
+			if v < lo[i] {
+				lo[i] = v
+			}
+			if v > hi[i] {
+				hi[i] = v
+			}
+		}
+	}
+	for i := range lo {
+		if int(hi[i])-int(lo[i]) > r {
+			channel, r = i, int(hi[i])-int(lo[i])
+		}
+	}
+	return channel, r
+}
+
+// octreeNode is a node of a tree of colors.  Each level of the tree splits
+// colors by the next bit of each of their four channels, so nodes have up
+// to 16 children.
+type octreeNode struct {
+	children [16]*octreeNode
+	leaf     bool
+	sum      [4]int // sums of the channels of the pixels below the node
+	n        int    // number of pixels below the node
+}
+
+func (node *octreeNode) add(c colorCount) {
+	for i, v := range c.c {
+		node.sum[i] += int(v) * c.n
+	}
+	node.n += c.n
+}
+
+// octreePalette returns up to n colors chosen from colors with an octree
+func octreePalette(colors []colorCount, n int) []color.RGBA {
+	root := &octreeNode{}
+	levels := [8][]*octreeNode{{root}} // nodes that are not leaves, by level
+	leaves := 0
+	for _, c := range colors {
+		node := root
+		for level := 0; level < 8; level++ {
+			node.add(c)
+			shift := uint(7 - level)
+			i := (c.c[0]>>shift&1)<<3 | (c.c[1]>>shift&1)<<2 | (c.c[2]>>shift&1)<<1 | c.c[3]>>shift&1
+			child := node.children[i]
+			if child == nil {
+				child = &octreeNode{leaf: level == 7}
+				node.children[i] = child
+				if level < 7 {
+					levels[level+1] = append(levels[level+1], child)
+				} else {
+					leaves++
+				}
+			}
+			node = child
+		}
+		node.add(c)
+	}
+
+	// merge the children of the nodes with the fewest pixels, from the
+	// bottom of the tree up, until there are few enough leaves
+	for level := 7; level >= 0 && leaves > n; level-- {
+		nodes := levels[level]
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].n < nodes[j].n })
+		for _, node := range nodes {
+			if leaves <= n {
+				break
+			}
+			children := 0
+			for i, child := range node.children {
+				if child != nil {
+					children++
+					node.children[i] = nil
+				}
+			}
+			node.leaf = true
+			leaves -= children - 1
+		}
+	}
+
+	var palette []color.RGBA
+	var walk func(node *octreeNode)
+	walk = func(node *octreeNode) {
+		if node.leaf {
+			palette = append(palette, color.RGBA{
+				uint8((node.sum[0] + node.n/2) / node.n),
+				uint8((node.sum[1] + node.n/2) / node.n),
+				uint8((node.sum[2] + node.n/2) / node.n),
+				uint8((node.sum[3] + node.n/2) / node.n),
+			})
+			return
+		}
+		for _, child := range node.children {
+			if child != nil {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+	return palette
+}
+
+// quantize returns img drawn with the nearest colors of a palette of up to
+// n colors chosen by q
+func quantize(img image.Image, q Quantizer, n int) *image.Paletted {
+	if n > 256 {
+		n = 256
+	}
+	rgba := toRGBA(img)
+	p := q.Quantize(make(color.Palette, 0, n), rgba)
+
+	paletted := image.NewPaletted(rgba.Bounds(), p)
+	indexes := make(map[color.RGBA]uint8)
+	for i, j := 0, 0; i < len(rgba.Pix); i, j = i+4, j+1 {
+		c := color.RGBA{rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2], rgba.Pix[i+3]}
+		index, ok := indexes[c]
+		if !ok {
+			index = uint8(p.Index(c))
+			indexes[c] = index
+		}
+		paletted.Pix[j] = index
+	}
+	return paletted
+}
+
+// sharedPalette tracks whether the tiles of a merged image share the same
+// palette.  It is safe for concurrent use.
+type sharedPalette struct {
+	mu      sync.Mutex
+	palette color.Palette
+	shared  bool
+	seen    bool
+}
+
+// add adds a decoded tile; tiles without a palette are never shared
+func (s *sharedPalette) add(img image.Image) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paletted, ok := img.(*image.Paletted)
+	if !s.seen {
+		s.seen = true
+		s.shared = ok
+		if ok {
+			s.palette = paletted.Palette
+		}
+		return
+	}
+	s.shared = s.shared && ok && equalPalettes(s.palette, paletted.Palette)
+}
+
+func equalPalettes(a, b color.Palette) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		r0, g0, b0, a0 := a[i].RGBA()
+		r1, g1, b1, a1 := b[i].RGBA()
+		if r0 != r1 || g0 != g1 || b0 != b1 || a0 != a1 {
+			return false
+		}
+	}
+	return true
+}
+
+// toPaletted returns img as an *image.Paletted with palette p, or nil if
+// any pixel of img is not a color in p
+func toPaletted(img *image.RGBA, p color.Palette) *image.Paletted {
+	indexes := make(map[color.RGBA]uint8, len(p))
+	for i := len(p) - 1; i >= 0; i-- {
+		// the first index of colors repeated in p is used
+		indexes[color.RGBAModel.Convert(p[i]).(color.RGBA)] = uint8(i)
+	}
+
+	paletted := image.NewPaletted(img.Bounds(), p)
+	for i, j := 0, 0; i < len(img.Pix); i, j = i+4, j+1 {
+		index, ok := indexes[color.RGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}]
+		if !ok {
+			return nil
+		}
+		paletted.Pix[j] = index
+	}
+	return paletted
+}
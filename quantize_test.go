@@ -0,0 +1,158 @@
+package tilemerge
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+var testPalette = color.Palette{
+	color.RGBA{0, 0, 0, 0},
+	color.RGBA{170, 211, 223, 255},
+	color.RGBA{242, 239, 233, 255},
+	color.RGBA{200, 250, 204, 255},
+	color.RGBA{230, 50, 50, 255},
+}
+
+// palettedTiles returns 2x2 tiles at zoom 1 that are paletted with p
+func palettedTiles(p color.Palette) Tiles {
+	tiles := Tiles{X0: 0, Y0: 0, X1: 1, Y1: 1}
+	for ty := 0; ty <= 1; ty++ {
+		for tx := 0; tx <= 1; tx++ {
+			img := image.NewPaletted(image.Rect(0, 0, TILE_SIZE, TILE_SIZE), p)
+			for y := 0; y < TILE_SIZE; y++ {
+				for x := 0; x < TILE_SIZE; x++ {
+					img.SetColorIndex(x, y, uint8((tx+ty+x/16+y/16)%len(p)))
+				}
+			}
+			tiles.Tiles = append(tiles.Tiles, Tile{Z: 1, X: tx, Y: ty, Data: encodePNG(img)})
+		}
+	}
+	return tiles
+}
+
+func Test_Merge_Paletted(t *testing.T) {
+	img, err := Merge(palettedTiles(testPalette), 100, 50, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paletted, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("Merge() returned %T, expected *image.Paletted", img)
+	}
+	if !equalPalettes(paletted.Palette, testPalette) {
+		t.Errorf("Merge() returned palette %v, expected %v", paletted.Palette, testPalette)
+	}
+	verifyDimensions(t, img, 300, 200)
+
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			px, py := x+100, y+50
+			tx, ty := px/TILE_SIZE, py/TILE_SIZE
+			expected := uint8((tx + ty + px%TILE_SIZE/16 + py%TILE_SIZE/16) % len(testPalette))
+			if index := paletted.ColorIndexAt(x, y); index != expected {
+				t.Fatalf("Merge() pixel %v, %v has index %v, expected %v", x, y, index, expected)
+			}
+		}
+	}
+}
+
+func Test_Merge_Paletted_RGBA(t *testing.T) {
+	opaque := testPalette[1:]
+	other := append(color.Palette{color.RGBA{0, 0, 255, 255}}, testPalette[1:]...)
+
+	mixed := palettedTiles(testPalette)
+	mixed.Tiles[3] = palettedTiles(other).Tiles[3]
+
+	missing := palettedTiles(opaque)
+	missing.Tiles[3].Data = nil
+
+	transparent := palettedTiles(testPalette)
+	transparent.Tiles[3].Data = nil
+
+	rgb := palettedTiles(testPalette)
+	rgb.Tiles[3].Data = encodePNG(image.NewRGBA(image.Rect(0, 0, TILE_SIZE, TILE_SIZE)))
+
+	cases := []struct {
+		name     string
+		tiles    Tiles
+		bg       color.Color
+		paletted bool
+	}{
+		{"background in palette", palettedTiles(testPalette), testPalette[2], true},
+		{"background not in palette", palettedTiles(testPalette), color.RGBA{1, 2, 3, 255}, false},
+		{"different palettes", mixed, nil, false},
+		{"missing tile without transparent color", missing, nil, false},
+		{"missing tile with transparent color", transparent, nil, true},
+		{"tile without palette", rgb, nil, false},
+	}
+	for _, c := range cases {
+		// the merged image is wider than the tiles, so bg is visible
+		img, err := Merge(c.tiles, 0, 0, 600, 512, c.bg)
+		if err != nil {
+			t.Fatalf("%v: Merge() returned error: %v", c.name, err)
+		}
+		if _, ok := img.(*image.Paletted); ok != c.paletted {
+			t.Errorf("%v: Merge() returned %T, expected paletted %v", c.name, img, c.paletted)
+		}
+	}
+}
+
+func Test_Merge_Quantize(t *testing.T) {
+	original, err := Merge(jpgTiles(), 0, 0, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		quantizer Quantizer
+		filename  string
+	}{
+		{MedianCut, "test_quantize_median_cut.png"},
+		{Octree, "test_quantize_octree.png"},
+	}
+	for _, c := range cases {
+		img, err := Merge(jpgTiles(), 0, 0, 300, 200, nil, Quantize(c.quantizer, 16))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if *update {
+			exportPNG(img, "test_data/output/"+c.filename)
+		}
+
+		paletted, ok := img.(*image.Paletted)
+		if !ok {
+			t.Fatalf("Merge() with Quantize returned %T, expected *image.Paletted", img)
+		}
+		if len(paletted.Palette) > 16 {
+			t.Errorf("Merge() with Quantize returned %v colors, expected at most 16", len(paletted.Palette))
+		}
+		if diff := meanDifference(toRGBA(img), original); diff > 6 {
+			t.Errorf("quantized image differs from merged image by %v, expected at most 6", diff)
+		}
+		verifyPNG(t, img, "test_data/output/"+c.filename)
+	}
+}
+
+func Test_Quantizer_ExactColors(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 50, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 50; x++ {
+			img.Set(x, y, testPalette[(x*x+y)%len(testPalette)])
+		}
+	}
+
+	for _, q := range []Quantizer{MedianCut, Octree} {
+		p := q.Quantize(make(color.Palette, 0, 8), img)
+		if len(p) != len(testPalette) {
+			t.Errorf("Quantize() returned %v colors, expected %v", len(p), len(testPalette))
+		}
+
+		quantized := quantize(img, q, 8)
+		if diff := maxDifference(toRGBA(quantized), img); diff != 0 {
+			t.Errorf("quantized image differs from image with %v colors by %v", len(testPalette), diff)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package tilemerge
+
+import (
+	"errors"
+	"image"
+	"math"
+
+	"github.com/brendan-ward/tilemerge/mercator"
+)
+
+// WGS84WKT is the WGS 84 geographic coordinate reference system
+// (EPSG:4326) as well known text, as written by GDAL
+const WGS84WKT = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],` +
+	`PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],` +
+	`AUTHORITY["EPSG","4326"]]`
+
+// Projection converts between longitude / latitude and the coordinates of a
+// coordinate reference system, for reprojecting merged images with Reproject.
+// Y coordinates must increase to the north.
+type Projection interface {
+	// Project converts lon, lat (in degrees) into coordinates of the
+	// projection
+	Project(lon, lat float64) (x, y float64)
+	// Unproject converts coordinates of the projection into lon, lat.
+	// Coordinates outside the projection return NaN.
+	Unproject(x, y float64) (lon, lat float64)
+	// WKT returns the coordinate reference system as well known text
+	WKT() string
+}
+
+// LonLat is the WGS 84 geographic coordinate reference system (EPSG:4326),
+// which draws longitude and latitude in degrees on an equirectangular
+// (plate carrée) grid
+type LonLat struct{}
+
+// Project returns lon, lat unchanged
+func (LonLat) Project(lon, lat float64) (x, y float64) {
+	return lon, lat
+}
+
+// Unproject returns x, y unchanged, or NaN beyond the poles
+func (LonLat) Unproject(x, y float64) (lon, lat float64) {
+	if y < -90 || y > 90 {
+		return math.NaN(), math.NaN()
+	}
+	return x, y
+}
+
+// WKT returns WGS84WKT
+func (LonLat) WKT() string {
+	return WGS84WKT
+}
+
+// WebMercator is the Web Mercator projection (EPSG:3857) of merged images,
+// in meters
+type WebMercator struct{}
+
+// Project converts lon, lat into Web Mercator meters.  Latitudes beyond
+// mercator.MaxLatitude are outside the square world, but are not clamped.
+func (WebMercator) Project(lon, lat float64) (x, y float64) {
+	x = lon * math.Pi / 180 * mercator.EarthRadius
+	y = math.Log(math.Tan(math.Pi/4+lat*math.Pi/360)) * mercator.EarthRadius
+	return x, y
+}
+
+// Unproject converts Web Mercator meters into lon, lat
+func (WebMercator) Unproject(x, y float64) (lon, lat float64) {
+	lon = x / mercator.EarthRadius * 180 / math.Pi
+	lat = (2*math.Atan(math.Exp(y/mercator.EarthRadius)) - math.Pi/2) * 180 / math.Pi
+	return lon, lat
+}
+
+// WKT returns WebMercatorWKT
+func (WebMercator) WKT() string {
+	return WebMercatorWKT
+}
+
+// edgeSteps is the number of points along each edge of an image that are
+// projected to find its bounds in another projection
+const edgeSteps = 32
+
+// Reproject draws img, georeferenced in Web Mercator by geo, in proj.
+// Each pixel of the result is mapped back through proj to lon, lat, and then
+// to a pixel of img, which is resampled with filter.  Pixels that map outside
+// img are transparent.
+//
+// The result covers the bounds of img in proj, with square pixels and the
+// same width as img.  Its georeferencing is returned as a Sidecar, which can
+// be written next to the encoded result with WriteSidecars.
+func Reproject(img image.Image, geo Georeference, proj Projection, filter Filter) (*image.RGBA, Sidecar, error) {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil, Sidecar{}, errors.New("tilemerge: image to reproject must not be empty")
+	}
+	if geo.PixelSize <= 0 {
+		return nil, Sidecar{}, errors.New("tilemerge: georeference pixel size must be greater than 0")
+	}
+
+	var merc WebMercator
+	project := func(px, py float64) (x, y float64) {
+		return proj.Project(merc.Unproject(geo.meters(px, py)))
+	}
+
+	// bounds of img in proj, from points along its edges
+	w, h := float64(b.Dx()), float64(b.Dy())
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for i := 0; i <= edgeSteps; i++ {
+		t := float64(i) / edgeSteps
+		for _, p := range [][2]float64{{t * w, 0}, {t * w, h}, {0, t * h}, {w, t * h}} {
+			x, y := project(p[0], p[1])
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+		}
+	}
+	if !(maxX > minX && maxY > minY) {
+		return nil, Sidecar{}, errors.New("tilemerge: image cannot be reprojected")
+	}
+
+	size := (maxX - minX) / w
+	width, height := b.Dx(), int(math.Floor((maxY-minY)/size+0.5))
+	if height < 1 {
+		height = 1
+	}
+
+	src := toRGBA(img)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			lon, lat := proj.Unproject(minX+(float64(x)+0.5)*size, maxY-(float64(y)+0.5)*size)
+			if math.IsNaN(lon) || math.IsNaN(lat) {
+				continue
+			}
+			px, py := geo.pixel(merc.Project(lon, lat))
+			dst.SetRGBA(x, y, filter.sample(src, px, py))
+		}
+	}
+
+	return dst, Sidecar{
+		Transform: [6]float64{minX, size, 0, maxY, 0, -size},
+		WKT:       proj.WKT(),
+	}, nil
+}
@@ -0,0 +1,157 @@
+package tilemerge
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/brendan-ward/tilemerge/mercator"
+)
+
+func Test_Projections(t *testing.T) {
+	projections := []Projection{LonLat{}, WebMercator{}}
+	points := [][2]float64{{0, 0}, {-180, 85}, {123.456, -45.678}, {179.9, -85}}
+
+	for _, proj := range projections {
+		for _, p := range points {
+			x, y := proj.Project(p[0], p[1])
+			lon, lat := proj.Unproject(x, y)
+			if math.Abs(lon-p[0]) > 1e-9 || math.Abs(lat-p[1]) > 1e-9 {
+				t.Errorf("%T: Unproject(Project(%v, %v)) = %v, %v", proj, p[0], p[1], lon, lat)
+			}
+		}
+	}
+
+	// Web Mercator meters match pixels projected by the mercator package
+	px, py := mercator.Project(-90, 45, 3, 256)
+	mx, my := mercator.Meters(px, py, 3, 256)
+	if x, y := (WebMercator{}).Project(-90, 45); math.Abs(x-mx) > 1e-6 || math.Abs(y-my) > 1e-6 {
+		t.Errorf("WebMercator.Project(-90, 45) = %v, %v, expected %v, %v", x, y, mx, my)
+	}
+
+	if lon, lat := (LonLat{}).Unproject(0, 91); !math.IsNaN(lon) || !math.IsNaN(lat) {
+		t.Errorf("LonLat.Unproject(0, 91) = %v, %v, expected NaN", lon, lat)
+	}
+}
+
+func Test_Reproject_WebMercator(t *testing.T) {
+	tiles := jpgTiles()
+	img, err := Merge(tiles, 100, 50, 300, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	geo, err := TilesGeoreference(tiles, 100, 50, 300, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reprojecting into the same projection changes nothing
+	reprojected, sidecar, err := Reproject(img, geo, WebMercator{}, NearestNeighbor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyDimensions(t, reprojected, 300, 200)
+	if diff := maxDifference(reprojected, img); diff != 0 {
+		t.Errorf("image reprojected into Web Mercator differs from merged image by %v", diff)
+	}
+
+	expected := geo.Sidecar()
+	for i := range expected.Transform {
+		if math.Abs(sidecar.Transform[i]-expected.Transform[i]) > 1e-6 {
+			t.Errorf("reprojected transform is %v, expected %v", sidecar.Transform, expected.Transform)
+			break
+		}
+	}
+}
+
+func Test_Reproject_LonLat(t *testing.T) {
+	tiles := jpgTiles()
+	img, err := Merge(tiles, 0, 0, 512, 512, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	geo, err := TilesGeoreference(tiles, 0, 0, 512, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reprojected, sidecar, err := Reproject(img, geo, LonLat{}, Bilinear)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		exportPNG(reprojected, "test_data/output/test_reproject_lonlat.png")
+	}
+
+	// the world is 360 degrees wide and about 170 degrees high
+	size := 360.0 / 512
+	expected := [6]float64{-180, size, 0, mercator.MaxLatitude, 0, -size}
+	for i := range expected {
+		if math.Abs(sidecar.Transform[i]-expected[i]) > 1e-6 {
+			t.Errorf("reprojected transform is %v, expected %v", sidecar.Transform, expected)
+			break
+		}
+	}
+	if sidecar.WKT != WGS84WKT {
+		t.Errorf("reprojected WKT is %v, expected WGS 84", sidecar.WKT)
+	}
+	verifyDimensions(t, reprojected, 512, int(math.Floor(2*mercator.MaxLatitude/size+0.5)))
+
+	// the world covers the image without empty edges
+	for i := 3; i < len(reprojected.Pix); i += 4 {
+		if reprojected.Pix[i] != 0xff {
+			t.Fatalf("reprojected pixel %v is not opaque", i/4)
+		}
+	}
+
+	verifyPNG(t, reprojected, "test_data/output/test_reproject_lonlat.png")
+}
+
+func Test_Reproject_Outside(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	// a projection that is only defined east of the prime meridian
+	proj := eastOnly{}
+	geo := Georeference{X: -5, Y: 5, PixelSize: 1}
+	reprojected, _, err := Reproject(img, geo, proj, NearestNeighbor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := reprojected.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			lon, _ := WebMercator{}.Unproject(-5+float64(x)+0.5, 0)
+			expected := color.RGBA{}
+			if lon >= 0 {
+				expected = color.RGBA{0xff, 0xff, 0xff, 0xff}
+			}
+			if c := reprojected.RGBAAt(x, y); c != expected {
+				t.Fatalf("reprojected pixel %v, %v is %v, expected %v", x, y, c, expected)
+			}
+		}
+	}
+
+	if _, _, err := Reproject(image.NewRGBA(image.Rectangle{}), geo, LonLat{}, Bilinear); err == nil {
+		t.Error("Reproject() did not return error for empty image")
+	}
+	if _, _, err := Reproject(img, Georeference{}, LonLat{}, Bilinear); err == nil {
+		t.Error("Reproject() did not return error for missing georeference")
+	}
+}
+
+// eastOnly is Web Mercator, but undefined west of the prime meridian
+type eastOnly struct {
+	WebMercator
+}
+
+func (p eastOnly) Unproject(x, y float64) (lon, lat float64) {
+	if x < 0 {
+		return math.NaN(), math.NaN()
+	}
+	return p.WebMercator.Unproject(x, y)
+}
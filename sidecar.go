@@ -0,0 +1,143 @@
+package tilemerge
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WebMercatorWKT is the Web Mercator (EPSG:3857) coordinate reference
+// system as well known text, as written by GDAL
+const WebMercatorWKT = `PROJCS["WGS 84 / Pseudo-Mercator",` +
+	`GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],` +
+	`PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]],` +
+	`PROJECTION["Mercator_1SP"],PARAMETER["central_meridian",0],PARAMETER["scale_factor",1],` +
+	`PARAMETER["false_easting",0],PARAMETER["false_northing",0],UNIT["metre",1,AUTHORITY["EPSG","9001"]],` +
+	`AXIS["Easting",EAST],AXIS["Northing",NORTH],` +
+	`EXTENSION["PROJ4","+proj=merc +a=6378137 +b=6378137 +lat_ts=0 +lon_0=0 +x_0=0 +y_0=0 +k=1 +units=m +nadgrids=@null +wktext +no_defs"],` +
+	`AUTHORITY["EPSG","3857"]]`
+
+// Sidecar is the georeferencing of an image in a format that cannot hold it,
+// such as JPEG or PNG, which GIS tools read from files next to the image
+type Sidecar struct {
+	// Transform is the affine transform from pixel to map coordinates, in the
+	// order of a GDAL GeoTransform: the X coordinate of the upper left corner
+	// of the image, the width of pixels, the row rotation, the Y coordinate
+	// of the upper left corner, the column rotation, and the height of pixels,
+	// which is negative for images with north up
+	Transform [6]float64
+	// WKT is the coordinate reference system as well known text
+	WKT string
+}
+
+// Sidecar returns the Sidecar for an image georeferenced by g
+func (g Georeference) Sidecar() Sidecar {
+	return Sidecar{
+		Transform: g.transform(),
+		WKT:       WebMercatorWKT,
+	}
+}
+
+// WorldFile returns the six parameters of an ESRI world file, in order:
+// the width of pixels, the row and column rotations, the height of pixels,
+// and the X and Y coordinates of the center of the upper left pixel
+func (s Sidecar) WorldFile() [6]float64 {
+	t := s.Transform
+	return [6]float64{
+		t[1], t[4], t[2], t[5],
+		t[0] + t[1]/2 + t[2]/2,
+		t[3] + t[4]/2 + t[5]/2,
+	}
+}
+
+// WriteWorldFile writes s to w as an ESRI world file
+func (s Sidecar) WriteWorldFile(w io.Writer) error {
+	for _, v := range s.WorldFile() {
+		if _, err := fmt.Fprintf(w, "%.10f\n", v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WritePRJ writes the coordinate reference system of s to w as an ESRI
+// .prj file
+func (s Sidecar) WritePRJ(w io.Writer) error {
+	_, err := io.WriteString(w, s.WKT)
+	return err
+}
+
+// pamDataset is the root element of a GDAL .aux.xml file
+type pamDataset struct {
+	XMLName      xml.Name `xml:"PAMDataset"`
+	SRS          string   `xml:"SRS"`
+	GeoTransform string   `xml:"GeoTransform"`
+}
+
+// WriteAuxXML writes s to w as a GDAL .aux.xml file
+func (s Sidecar) WriteAuxXML(w io.Writer) error {
+	values := make([]string, len(s.Transform))
+	for i, v := range s.Transform {
+		values[i] = fmt.Sprintf("%24.16e", v)
+	}
+
+	data, err := xml.MarshalIndent(pamDataset{
+		SRS:          s.WKT,
+		GeoTransform: strings.Join(values, ","),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WorldFileExt returns the extension of the world file for the image at
+// path: ".pgw" for PNG, ".jgw" for JPEG, and ".wld" for other formats
+func WorldFileExt(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return ".pgw"
+	case ".jpg", ".jpeg":
+		return ".jgw"
+	}
+	return ".wld"
+}
+
+// WriteSidecars writes the world file, .prj and .aux.xml files for the image
+// at path next to it.  The world file and .prj replace the extension of
+// path; the .aux.xml is appended to it, as GDAL expects.
+func WriteSidecars(path string, s Sidecar) error {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+
+	files := []struct {
+		path  string
+		write func(io.Writer) error
+	}{
+		{base + WorldFileExt(path), s.WriteWorldFile},
+		{base + ".prj", s.WritePRJ},
+		{path + ".aux.xml", s.WriteAuxXML},
+	}
+	for _, file := range files {
+		if err := writeFile(file.path, file.write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile creates the file at path and writes to it with write
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
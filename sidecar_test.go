@@ -0,0 +1,116 @@
+package tilemerge
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testSidecar(t *testing.T) Sidecar {
+	geo, err := TilesGeoreference(jpgTiles(), 100, 50, 300, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return geo.Sidecar()
+}
+
+func Test_Sidecar_WorldFile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testSidecar(t).WriteWorldFile(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// upper left pixel center is half a pixel from 100, 50 at zoom 1
+	expected := "78271.5169640205\n" +
+		"0.0000000000\n" +
+		"0.0000000000\n" +
+		"-78271.5169640205\n" +
+		"-12171220.8879051842\n" +
+		"16084796.7361062076\n"
+	if buf.String() != expected {
+		t.Errorf("world file is\n%v\nexpected\n%v", buf.String(), expected)
+	}
+}
+
+func Test_Sidecar_WorldFile_Bearing(t *testing.T) {
+	geo, err := TilesGeoreference(jpgTiles(), 100, 50, 300, 200, Bearing(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// world file parameters map the center of pixels to the same meters as
+	// the transform
+	w := geo.Sidecar().WorldFile()
+	for _, p := range [][2]float64{{0, 0}, {299, 0}, {0, 199}, {150, 100}} {
+		x, y := geo.meters(p[0]+0.5, p[1]+0.5)
+		wx, wy := w[0]*p[0]+w[2]*p[1]+w[4], w[1]*p[0]+w[3]*p[1]+w[5]
+		if math.Abs(x-wx) > 1e-6 || math.Abs(y-wy) > 1e-6 {
+			t.Errorf("world file maps pixel %v to %v, %v, expected %v, %v", p, wx, wy, x, y)
+		}
+	}
+}
+
+func Test_Sidecar_AuxXML(t *testing.T) {
+	s := testSidecar(t)
+
+	var buf bytes.Buffer
+	if err := s.WriteAuxXML(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var pam pamDataset
+	if err := xml.Unmarshal(buf.Bytes(), &pam); err != nil {
+		t.Fatalf("parsing .aux.xml returned error: %v", err)
+	}
+	if pam.SRS != WebMercatorWKT {
+		t.Errorf(".aux.xml has SRS %v, expected Web Mercator", pam.SRS)
+	}
+	expected := " -1.2210356646387195e+07,  7.8271516964020484e+04,  0.0000000000000000e+00," +
+		"  1.6123932494588219e+07,  0.0000000000000000e+00, -7.8271516964020484e+04"
+	if pam.GeoTransform != expected {
+		t.Errorf(".aux.xml has GeoTransform %v, expected %v", pam.GeoTransform, expected)
+	}
+}
+
+func Test_WriteSidecars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tilemerge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := testSidecar(t)
+	cases := []struct {
+		image    string
+		expected []string
+	}{
+		{"merged.png", []string{"merged.pgw", "merged.prj", "merged.png.aux.xml"}},
+		{"merged.JPG", []string{"merged.jgw", "merged.prj", "merged.JPG.aux.xml"}},
+		{"merged.jpeg", []string{"merged.jgw", "merged.prj", "merged.jpeg.aux.xml"}},
+		{"merged.webp", []string{"merged.wld", "merged.prj", "merged.webp.aux.xml"}},
+	}
+	for _, c := range cases {
+		if err := WriteSidecars(filepath.Join(dir, c.image), s); err != nil {
+			t.Fatalf("WriteSidecars() for %v returned error: %v", c.image, err)
+		}
+		for _, name := range c.expected {
+			data, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Errorf("WriteSidecars() for %v did not write %v", c.image, name)
+				continue
+			}
+			if strings.HasSuffix(name, ".prj") && string(data) != WebMercatorWKT {
+				t.Errorf("%v is %v, expected Web Mercator", name, string(data))
+			}
+		}
+	}
+
+	if err := WriteSidecars(filepath.Join(dir, "missing", "merged.png"), s); err == nil {
+		t.Error("WriteSidecars() did not return error for missing directory")
+	}
+}
@@ -0,0 +1,130 @@
+package tilemerge
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+
+	"github.com/brendan-ward/tilemerge/mercator"
+)
+
+// TileSource provides the encoded image data of tiles, so that they can be
+// requested as they are needed for merging
+type TileSource interface {
+	// GetTile returns the encoded image data for the tile at z, x, y, or nil
+	// if there is no tile at that coordinate
+	GetTile(ctx context.Context, z uint8, x, y int) ([]byte, error)
+}
+
+// TileSourceFunc is an adapter to use an ordinary function as a TileSource
+type TileSourceFunc func(ctx context.Context, z uint8, x, y int) ([]byte, error)
+
+// GetTile calls f(ctx, z, x, y)
+func (f TileSourceFunc) GetTile(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+	return f(ctx, z, x, y)
+}
+
+type tileKey struct {
+	z    uint8
+	x, y int
+}
+
+// MemorySource is a TileSource for tiles that are already loaded into memory
+type MemorySource struct {
+	tiles map[tileKey][]byte
+}
+
+// NewMemorySource returns a MemorySource containing tiles that have data
+func NewMemorySource(tiles Tiles) *MemorySource {
+	s := &MemorySource{tiles: make(map[tileKey][]byte, len(tiles.Tiles))}
+	for _, tile := range tiles.Tiles {
+		if tile.Data != nil {
+			s.tiles[tileKey{tile.Z, tile.X, tile.Y}] = *tile.Data
+		}
+	}
+	return s
+}
+
+// GetTile returns the data of the tile at z, x, y, or nil if it is not in s
+func (s *MemorySource) GetTile(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+	return s.tiles[tileKey{z, x, y}], nil
+}
+
+// MergeSource merges tiles from src at zoom z into a single Image with
+// dimensions `width` and `height`, cropped based on xOff, yOff from the
+// upper left of tile x0, y0.
+// Only the tiles that intersect the cropped image are requested from src.
+// Any tile that src does not have is left empty, or filled with `bg` if provided.
+// Tile columns are wrapped across the anti-meridian and tiles above or below
+// the world are left empty, as MergeView does.
+//
+// If the tile size is set to AutoTileSize, it is detected from the first
+// tile within the cropped image that src has, or is TILE_SIZE if src has none.
+//
+// ctx is passed to each call to src.GetTile.  If ctx is canceled before all
+// tiles have been decoded, MergeSource stops and returns ctx.Err().
+func MergeSource(ctx context.Context, src TileSource, z uint8, x0, y0, xOff, yOff, width, height int, bg color.Color, opts ...Option) (image.Image, error) {
+	o := newOptions(opts)
+	src = newWorldSource(src)
+
+	if o.tileSize == AutoTileSize {
+		size, err := detectSourceTileSize(ctx, src, z, x0, y0, xOff, yOff, width, height)
+		if err != nil {
+			return nil, err
+		}
+		o.tileSize = size
+	}
+
+	return mergeOutput(o, z, x0*o.tileSize+xOff, y0*o.tileSize+yOff, width, height, func(x, y, width, height int) (image.Image, error) {
+		return merge(ctx, src, z, x, y, width, height, bg, o)
+	})
+}
+
+// detectSourceTileSize returns the width of the first tile that src has
+// within the area cropped based on xOff, yOff from the upper left of tile
+// x0, y0, or TILE_SIZE if src has none.  The tiles within the area depend on
+// the tile size, so if the first tile is not TILE_SIZE, the tiles within the
+// area for its size are searched again.
+func detectSourceTileSize(ctx context.Context, src TileSource, z uint8, x0, y0, xOff, yOff, width, height int) (int, error) {
+	size, err := firstTileSize(ctx, src, z, x0*TILE_SIZE+xOff, y0*TILE_SIZE+yOff, width, height, TILE_SIZE)
+	if err != nil || size == 0 {
+		return TILE_SIZE, err
+	}
+	if size == TILE_SIZE {
+		return size, nil
+	}
+
+	found, err := firstTileSize(ctx, src, z, x0*size+xOff, y0*size+yOff, width, height, size)
+	if err != nil || found == 0 {
+		return size, err
+	}
+	// tiles of other sizes than found are rejected by merge
+	return found, nil
+}
+
+// firstTileSize returns the width of the first tile that src has within the
+// area of width by height pixels with its upper left corner at pixel x, y,
+// for tiles that are tileSize pixels square, or 0 if src has none
+func firstTileSize(ctx context.Context, src TileSource, z uint8, x, y, width, height, tileSize int) (int, error) {
+	x0, x1 := mercator.TileIndex(x, tileSize), mercator.TileIndex(x+width-1, tileSize)
+	y0, y1 := mercator.TileIndex(y, tileSize), mercator.TileIndex(y+height-1, tileSize)
+	for ty := y0; ty <= y1; ty++ {
+		for tx := x0; tx <= x1; tx++ {
+			data, err := src.GetTile(ctx, z, tx, ty)
+			if err != nil {
+				return 0, err
+			}
+			if data == nil {
+				continue
+			}
+
+			config, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				return 0, err
+			}
+			return config.Width, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,154 @@
+package tilemerge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"testing"
+)
+
+// countingSource records the tiles requested from it
+type countingSource struct {
+	src       TileSource
+	requested []string
+}
+
+func (s *countingSource) GetTile(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+	s.requested = append(s.requested, fmt.Sprintf("%v/%v/%v", z, x, y))
+	return s.src.GetTile(ctx, z, x, y)
+}
+
+func Test_MemorySource(t *testing.T) {
+	tiles := jpgTiles()
+	tiles.Tiles[0].Data = nil
+	src := NewMemorySource(tiles)
+
+	for _, tile := range tiles.Tiles {
+		data, err := src.GetTile(context.Background(), tile.Z, tile.X, tile.Y)
+		if err != nil {
+			panic(err)
+		}
+		if tile.Data == nil {
+			if data != nil {
+				t.Errorf("GetTile(%v, %v, %v) returned data for missing tile", tile.Z, tile.X, tile.Y)
+			}
+			continue
+		}
+		if !bytes.Equal(data, *tile.Data) {
+			t.Errorf("GetTile(%v, %v, %v) did not return tile data", tile.Z, tile.X, tile.Y)
+		}
+	}
+
+	if data, _ := src.GetTile(context.Background(), 2, 1, 1); data != nil {
+		t.Error("GetTile() returned data for tile at a different zoom")
+	}
+}
+
+// MergeSource should produce the same output as Merge for the same tiles
+func Test_MergeSource(t *testing.T) {
+	tiles := jpgTiles()
+	xOff := 100
+	yOff := 50
+	width := 2*TILE_SIZE - xOff - 75
+	height := 2*TILE_SIZE - yOff - 45
+
+	expected, err := Merge(tiles, xOff, yOff, width, height, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	img, err := MergeSource(context.Background(), testTiles("jpg"), 1, 0, 0, xOff, yOff, width, height, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	verifyDimensions(t, img, width, height)
+	if !bytes.Equal(img.(*image.RGBA).Pix, expected.(*image.RGBA).Pix) {
+		t.Error("MergeSource() did not match Merge() of the same tiles")
+	}
+}
+
+// Only tiles that intersect the cropped image should be requested
+func Test_MergeSource_Crop_Window(t *testing.T) {
+	cases := []struct {
+		xOff, yOff, width, height int
+		requested                 []string
+	}{
+		{0, 0, 512, 512, []string{"1/0/0", "1/1/0", "1/0/1", "1/1/1"}},
+		{0, 0, 256, 256, []string{"1/0/0"}},
+		{300, 0, 200, 256, []string{"1/1/0"}},
+		{100, 300, 200, 100, []string{"1/0/1", "1/1/1"}},
+		// tiles above the world are not requested, and tile columns wrap
+		{-100, -100, 200, 200, []string{"1/1/0", "1/0/0"}},
+	}
+
+	for _, c := range cases {
+		src := &countingSource{src: testTiles("jpg")}
+		_, err := MergeSource(context.Background(), src, 1, 0, 0, c.xOff, c.yOff, c.width, c.height, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		if fmt.Sprint(src.requested) != fmt.Sprint(c.requested) {
+			t.Errorf("MergeSource(%v, %v, %v, %v) requested %v, expected %v",
+				c.xOff, c.yOff, c.width, c.height, src.requested, c.requested)
+		}
+	}
+}
+
+// MergeSource should wrap across the anti-meridian and leave tiles outside
+// the world empty, as MergeView does
+func Test_MergeSource_World(t *testing.T) {
+	view := View{Zoom: 1, X: 256, Y: -100, Width: 512, Height: 300, TileSize: TILE_SIZE}
+	expected, err := MergeView(context.Background(), testTiles("jpg"), view, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	src := &countingSource{src: testTiles("jpg")}
+	img, err := MergeSource(context.Background(), src, 1, 1, 0, 0, -100, 512, 300, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if diff := maxDifference(img, expected); diff != 0 {
+		t.Errorf("MergeSource() across the anti-meridian differs from MergeView() by %v", diff)
+	}
+	expectedRequests := []string{"1/1/0", "1/0/0"}
+	if fmt.Sprint(src.requested) != fmt.Sprint(expectedRequests) {
+		t.Errorf("MergeSource() requested %v, expected %v", src.requested, expectedRequests)
+	}
+}
+
+func Test_MergeSource_TileSize_Auto(t *testing.T) {
+	img, err := MergeSource(context.Background(), testTiles("png"), 4, 2, 5, 0, 0, 300, 300, nil, TileSize(AutoTileSize))
+	if err != nil {
+		panic(err)
+	}
+
+	verifyDimensions(t, img, 300, 300)
+}
+
+func Test_MergeSource_TileSize_Auto_Crop_Window(t *testing.T) {
+	// tile 1, 5 is outside the cropped image, and src does not have it
+	src := &countingSource{src: retinaSource{testTiles("png")}}
+	img, err := MergeSource(context.Background(), src, 4, 1, 5, 600, 0, 600, 400, nil, TileSize(AutoTileSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := MergeSource(context.Background(), retinaSource{testTiles("png")}, 4, 1, 5, 600, 0, 600, 400, nil, TileSize(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := maxDifference(img, expected); diff != 0 {
+		t.Errorf("MergeSource() with AutoTileSize differs from tile size 512 by %v", diff)
+	}
+
+	for _, tile := range src.requested {
+		if tile == "4/1/5" {
+			t.Errorf("MergeSource() with AutoTileSize requested tile 4/1/5 outside the cropped image")
+		}
+	}
+}
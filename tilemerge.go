@@ -2,6 +2,8 @@ package tilemerge
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
@@ -9,16 +11,20 @@ import (
 	_ "image/jpeg" // load jpeg decoder
 	_ "image/png"  // load png decoder
 
+	"github.com/brendan-ward/tilemerge/mercator"
 	_ "golang.org/x/image/webp" //load webp decoder
 )
 
-// TILE_SIZE uses default size of map tiles for now
-// TODO: probably should be a parameter or detected from the input images
+// TILE_SIZE is the default size of map tiles
 const TILE_SIZE = 256
 
+// AutoTileSize can be passed to TileSize to detect the tile size from the
+// dimensions of the first decoded tile
+const AutoTileSize = 0
+
 // Tile is a container for basic information about a tile
 type Tile struct {
-	Z    uint8 // don't really need to know this here
+	Z    uint8 // zoom level; all tiles merged together must have the same Z
 	X, Y int
 	Data *[]byte // nil if there is no valid image data for this tile coordinate
 }
@@ -30,40 +36,171 @@ type Tiles struct {
 	// Rect image.Rectangle // create via image.Rect(x0, y0, x1, y1)
 }
 
+// TileSizeError is returned when the dimensions of a tile do not match the
+// tile size used for merging
+type TileSizeError struct {
+	Z             uint8
+	X, Y          int
+	Width, Height int // dimensions of the tile image
+	TileSize      int // expected width and height
+}
+
+func (e *TileSizeError) Error() string {
+	return fmt.Sprintf("tilemerge: tile %v/%v/%v is %vx%v, expected %vx%v",
+		e.Z, e.X, e.Y, e.Width, e.Height, e.TileSize, e.TileSize)
+}
+
 // Merge merges input Tiles into a single Image with dimenensions `width` and `height`,
 // and crops based on xOff, yOff from upper left of image.
 // Tile x and y coordinates increase from the upper left of the image.
-// Any tile that
-func Merge(tiles Tiles, xOff, yOff, width, height int, bg color.Color) (image.Image, error) {
+// Any tile that has no data is left empty, or filled with `bg` if provided.
+//
+// Tiles are assumed to be TILE_SIZE pixels square unless a different size is
+// set with the TileSize option.  Tiles that do not match the tile size
+// cause Merge to return a *TileSizeError.
+//
+// The merged image is an *image.Paletted if all tiles share the same palette
+// and it has every color of the merged image, including `bg` and, if any
+// tile has no data, transparent.  Otherwise it is an *image.RGBA.
+func Merge(tiles Tiles, xOff, yOff, width, height int, bg color.Color, opts ...Option) (image.Image, error) {
+	return MergeContext(context.Background(), tiles, xOff, yOff, width, height, bg, opts...)
+}
 
-	img := image.NewRGBA(image.Rect(0, 0,
-		(tiles.X1-tiles.X0+1)*TILE_SIZE,
-		(tiles.Y1-tiles.Y0+1)*TILE_SIZE))
+// MergeContext is like Merge, but stops merging and returns ctx.Err() if
+// ctx is canceled before all tiles have been decoded.
+func MergeContext(ctx context.Context, tiles Tiles, xOff, yOff, width, height int, bg color.Color, opts ...Option) (image.Image, error) {
+	o := newOptions(opts)
 
-	if bg != nil {
-		// Fill background color
-		draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.ZP, draw.Src)
+	var z uint8
+	if len(tiles.Tiles) > 0 {
+		z = tiles.Tiles[0].Z
+	}
+
+	if o.tileSize == AutoTileSize {
+		size, err := detectTileSize(tiles)
+		if err != nil {
+			return nil, err
+		}
+		o.tileSize = size
 	}
 
-	// tile transform is x = (tile.X - x0) * TILE_SIZE, y = (tile.Y - y) * TILE_SIZE
+	src := NewMemorySource(tiles)
+	return mergeOutput(o, z, tiles.X0*o.tileSize+xOff, tiles.Y0*o.tileSize+yOff, width, height, func(x, y, width, height int) (image.Image, error) {
+		return merge(ctx, src, z, x, y, width, height, bg, o)
+	})
+}
+
+// detectTileSize returns the width of the first tile with data, or TILE_SIZE
+// if no tile has data
+func detectTileSize(tiles Tiles) (int, error) {
 	for _, tile := range tiles.Tiles {
 		if tile.Data == nil {
 			continue
 		}
 
-		// for the upper left tile, x0, y0 should be 0
-		x0 := (tile.X - tiles.X0) * TILE_SIZE
-		y0 := (tile.Y - tiles.Y0) * TILE_SIZE
-		src, _, err := image.Decode(bytes.NewReader(*tile.Data))
+		config, _, err := image.DecodeConfig(bytes.NewReader(*tile.Data))
 		if err != nil {
-			return nil, err
+			return 0, err
+		}
+		return config.Width, nil
+	}
+	return TILE_SIZE, nil
+}
+
+// merge merges the tiles from src at zoom z that intersect the area of
+// width by height pixels with its upper left corner at pixel x, y
+// from the upper left of tile 0, 0.
+// Only tiles that intersect the area are requested from src.
+// The result keeps the palette shared by all tiles, if possible.
+func merge(ctx context.Context, src TileSource, z uint8, x, y, width, height int, bg color.Color, o *options) (image.Image, error) {
+	tileSize := o.tileSize
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	if bg != nil {
+		// Fill background color
+		draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.ZP, draw.Src)
+	}
+
+	x0 := mercator.TileIndex(x, tileSize)
+	y0 := mercator.TileIndex(y, tileSize)
+	x1 := mercator.TileIndex(x+width-1, tileSize)
+	y1 := mercator.TileIndex(y+height-1, tileSize)
+
+	// columns of sources that wrap the world repeat the same tile, which is
+	// requested once and drawn at each of them
+	_, wraps := src.(*worldSource)
+	var tiles []image.Point
+	columns := make(map[image.Point][]int)
+	for ty := y0; ty <= y1; ty++ {
+		for tx := x0; tx <= x1; tx++ {
+			tile := image.Pt(tx, ty)
+			if wraps {
+				tile.X = mercator.WrapX(tx, z)
+			}
+			if _, ok := columns[tile]; !ok {
+				tiles = append(tiles, tile)
+			}
+			columns[tile] = append(columns[tile], tx)
+		}
+	}
+
+	// tile transform is x = tile.X * tileSize - x, y = tile.Y * tileSize - y
+	var palette sharedPalette
+	var parents ancestors
+	err := forEachTile(ctx, tiles, o.workers, func(ctx context.Context, tile image.Point) error {
+		data, err := src.GetTile(ctx, z, tile.X, tile.Y)
+		if err != nil {
+			return err
 		}
 
-		draw.Draw(img, image.Rect(x0, y0, x0+TILE_SIZE, y0+TILE_SIZE), src, image.ZP, draw.Src)
+		var decoded image.Image
+		if data != nil {
+			decoded, err = decodeTile(data, z, tile.X, tile.Y, tileSize)
+		} else {
+			decoded, err = fallbackTile(ctx, src, &parents, z, tile.X, tile.Y, o)
+		}
+		if err != nil || decoded == nil {
+			return err
+		}
+		palette.add(decoded)
+
+		// for the upper left tile of the area, dx, dy are <= 0.
+		// Tiles do not overlap, so they can be drawn concurrently.
+		dy := tile.Y*tileSize - y
+		for _, tx := range columns[tile] {
+			dx := tx*tileSize - x
+			draw.Draw(img, image.Rect(dx, dy, dx+tileSize, dy+tileSize), decoded, decoded.Bounds().Min, o.op)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	cropped := image.NewRGBA(image.Rect(0, 0, width, height))
-	draw.Draw(cropped, image.Rect(-xOff, -yOff, width, height), img, image.ZP, draw.Src)
+	if palette.shared {
+		if paletted := toPaletted(img, palette.palette); paletted != nil {
+			return paletted, nil
+		}
+	}
+	return img, nil
+}
+
+// decodeTile decodes the image data of the tile at z, x, y, and checks that
+// it is tileSize pixels square
+func decodeTile(data []byte, z uint8, x, y int, tileSize int) (image.Image, error) {
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
 
-	return cropped, nil
+	b := decoded.Bounds()
+	if b.Dx() != tileSize || b.Dy() != tileSize {
+		return nil, &TileSizeError{
+			Z: z, X: x, Y: y,
+			Width: b.Dx(), Height: b.Dy(),
+			TileSize: tileSize,
+		}
+	}
+	return decoded, nil
 }
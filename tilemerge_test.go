@@ -2,13 +2,15 @@ package tilemerge
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	"image/jpeg"
+	"image/draw"
 	"image/png"
 	"io/ioutil"
+	"math"
 	"os"
 	"testing"
 )
@@ -23,12 +25,19 @@ func loadTiles(z uint8, x0, y0, x1, y1 int, ext string) Tiles {
 		Tiles: make([]Tile, (x1-x0+1)*(y1-y0+1)),
 	}
 
+	src := testTiles(ext)
 	i := 0
 	for y := y0; y <= y1; y++ {
 		for x := x0; x <= x1; x++ {
-			tiles.Tiles[i] = Tile{
-				Z: z, X: x, Y: y, Data: readFile(fmt.Sprintf("test_data/%v_%v_%v.%s", z, x, y, ext)),
+			data, err := src.GetTile(context.Background(), z, x, y)
+			if err != nil {
+				panic(err)
 			}
+			if data == nil {
+				panic(fmt.Errorf("tile not found: %v", src.Path(z, x, y)))
+			}
+
+			tiles.Tiles[i] = Tile{Z: z, X: x, Y: y, Data: &data}
 
 			i++
 		}
@@ -36,11 +45,63 @@ func loadTiles(z uint8, x0, y0, x1, y1 int, ext string) Tiles {
 	return tiles
 }
 
+// testTiles returns a DirectorySource for tiles in test_data
+func testTiles(ext string) *DirectorySource {
+	return NewDirectorySource("test_data", "{z}_{x}_{y}."+ext)
+}
+
 // jpgTiles loads JPG tiles for testing
 func jpgTiles() Tiles {
 	return loadTiles(1, 0, 0, 1, 1, "jpg")
 }
 
+// encodePNG encodes img to PNG bytes, for use as tile data
+func encodePNG(img image.Image) *[]byte {
+	out := bytes.NewBuffer(nil)
+	if err := png.Encode(out, img); err != nil {
+		panic(err)
+	}
+	data := out.Bytes()
+	return &data
+}
+
+// mergedSize returns the width and height of the full extent of tiles
+func mergedSize(tiles Tiles, tileSize int) (int, int) {
+	return (1 + tiles.X1 - tiles.X0) * tileSize, (1 + tiles.Y1 - tiles.Y0) * tileSize
+}
+
+// maxDifference returns the largest difference between any channel of any
+// pixel of two *image.RGBA images with the same bounds
+func maxDifference(a, b image.Image) int {
+	max := 0
+	pa, pb := a.(*image.RGBA).Pix, b.(*image.RGBA).Pix
+	for i := range pa {
+		diff := int(pa[i]) - int(pb[i])
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > max {
+			max = diff
+		}
+	}
+	return max
+}
+
+// meanDifference returns the mean difference between the channels of the
+// pixels of two *image.RGBA images with the same bounds
+func meanDifference(a, b image.Image) float64 {
+	total := 0
+	pa, pb := a.(*image.RGBA).Pix, b.(*image.RGBA).Pix
+	for i := range pa {
+		diff := int(pa[i]) - int(pb[i])
+		if diff < 0 {
+			diff = -diff
+		}
+		total += diff
+	}
+	return float64(total) / float64(len(pa))
+}
+
 // Read file bytes
 func readFile(path string) *[]byte {
 	data, err := ioutil.ReadFile(path)
@@ -67,7 +128,7 @@ func exportJPG(img image.Image, path string) {
 	if err != nil {
 		panic(err)
 	}
-	jpeg.Encode(out, img, &jpeg.Options{Quality: 90})
+	Encode(out, img, JPG, JPEGQuality(JPG_QUALITY))
 }
 
 // exportPNG exports img to PNG to verify contents manually
@@ -77,7 +138,7 @@ func exportPNG(img image.Image, path string) {
 	if err != nil {
 		panic(err)
 	}
-	png.Encode(out, img)
+	Encode(out, img, PNG)
 }
 
 // verifyJPG compares image to golden image (known good image)
@@ -85,7 +146,7 @@ func verifyJPG(t *testing.T, img image.Image, goldenPath string) {
 	goldenBytes := readFile(goldenPath)
 
 	out := bytes.NewBuffer(nil)
-	jpeg.Encode(out, img, &jpeg.Options{Quality: JPG_QUALITY})
+	Encode(out, img, JPG, JPEGQuality(JPG_QUALITY))
 
 	if !bytes.Equal(out.Bytes(), *goldenBytes) {
 		t.Error("Merge() did not produce expected output; please verify image manually")
@@ -97,7 +158,7 @@ func verifyPNG(t *testing.T, img image.Image, goldenPath string) {
 	goldenBytes := readFile(goldenPath)
 
 	out := bytes.NewBuffer(nil)
-	png.Encode(out, img)
+	Encode(out, img, PNG)
 
 	if !bytes.Equal(out.Bytes(), *goldenBytes) {
 		t.Error("Merge() did not produce expected output; please verify image manually")
@@ -119,8 +180,7 @@ var update = flag.Bool("update", false, "update trusted output files")
 
 func Test_Merge_JPG(t *testing.T) {
 	tiles := jpgTiles()
-	width := (1 + tiles.X1 - tiles.X0) * TILE_SIZE
-	height := (1 + tiles.Y1 - tiles.Y0) * TILE_SIZE
+	width, height := mergedSize(tiles, TILE_SIZE)
 	img, err := Merge(tiles, 0, 0, width, height, nil)
 	if err != nil {
 		panic(err)
@@ -136,8 +196,7 @@ func Test_Merge_JPG(t *testing.T) {
 
 func Test_Merge_PNG(t *testing.T) {
 	tiles := loadTiles(4, 2, 5, 4, 6, "png")
-	width := (1 + tiles.X1 - tiles.X0) * TILE_SIZE
-	height := (1 + tiles.Y1 - tiles.Y0) * TILE_SIZE
+	width, height := mergedSize(tiles, TILE_SIZE)
 	img, err := Merge(tiles, 0, 0, width, height, nil)
 	if err != nil {
 		panic(err)
@@ -153,8 +212,7 @@ func Test_Merge_PNG(t *testing.T) {
 
 func Test_Merge_WEBP(t *testing.T) {
 	tiles := loadTiles(4, 3, 5, 4, 6, "webp")
-	width := (1 + tiles.X1 - tiles.X0) * TILE_SIZE
-	height := (1 + tiles.Y1 - tiles.Y0) * TILE_SIZE
+	width, height := mergedSize(tiles, TILE_SIZE)
 	img, err := Merge(tiles, 0, 0, width, height, nil)
 	if err != nil {
 		panic(err)
@@ -384,3 +442,288 @@ func Test_Merge_Background(t *testing.T) {
 	verifyDimensions(t, img, 2*TILE_SIZE, 2*TILE_SIZE)
 	verifyJPG(t, img, "test_data/output/test_background.jpg")
 }
+
+// Merge the JPG tiles into a single 512 pixel tile at zoom 0, which should
+// produce the same output as merging the original 256 pixel tiles
+func Test_Merge_TileSize_512(t *testing.T) {
+	tiles := jpgTiles()
+	width, height := mergedSize(tiles, TILE_SIZE)
+	full, err := Merge(tiles, 0, 0, width, height, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	large := Tiles{
+		X0: 0, Y0: 0, X1: 0, Y1: 0,
+		Tiles: []Tile{{Z: 0, X: 0, Y: 0, Data: encodePNG(full)}},
+	}
+
+	xOff := 100
+	yOff := 50
+	width = 300
+	height = 200
+
+	expected, err := Merge(tiles, xOff, yOff, width, height, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, tileSize := range []int{512, AutoTileSize} {
+		img, err := Merge(large, xOff, yOff, width, height, nil, TileSize(tileSize))
+		if err != nil {
+			t.Fatalf("Merge() with tile size %v returned error: %v", tileSize, err)
+		}
+
+		verifyDimensions(t, img, width, height)
+		if !bytes.Equal(img.(*image.RGBA).Pix, expected.(*image.RGBA).Pix) {
+			t.Errorf("Merge() with tile size %v did not match merged 256 pixel tiles", tileSize)
+		}
+	}
+}
+
+func Test_Merge_TileSize_Mismatch(t *testing.T) {
+	for _, tileSize := range []int{512, AutoTileSize} {
+		mixed := jpgTiles()
+		if tileSize == AutoTileSize {
+			// replace a later tile with one that is larger than the first tile
+			large := image.NewRGBA(image.Rect(0, 0, 512, 512))
+			mixed.Tiles[3].Data = encodePNG(large)
+		}
+
+		width, height := mergedSize(mixed, TILE_SIZE)
+		_, err := Merge(mixed, 0, 0, width, height, nil, TileSize(tileSize))
+
+		sizeErr, ok := err.(*TileSizeError)
+		if !ok {
+			t.Fatalf("Merge() with tile size %v did not return a *TileSizeError: %v", tileSize, err)
+		}
+
+		expected := tileSize
+		if tileSize == AutoTileSize {
+			expected = TILE_SIZE
+		}
+		if sizeErr.TileSize != expected {
+			t.Errorf("TileSizeError.TileSize = %v, expected %v", sizeErr.TileSize, expected)
+		}
+	}
+}
+
+func Test_Merge_TileSize_Auto_Missing(t *testing.T) {
+	tiles := jpgTiles()
+	for i := range tiles.Tiles {
+		tiles.Tiles[i].Data = nil
+	}
+	width, height := mergedSize(tiles, TILE_SIZE)
+	img, err := Merge(tiles, 0, 0, width, height, nil, TileSize(AutoTileSize))
+	if err != nil {
+		panic(err)
+	}
+
+	verifyDimensions(t, img, width, height)
+}
+
+func Test_Merge_TileSize_Invalid(t *testing.T) {
+	opt := TileSize(-5)
+	if _, err := Merge(jpgTiles(), 0, 0, 100, 100, nil, opt); err == nil {
+		t.Error("Merge() did not return error for invalid tile size")
+	}
+	if _, err := MergeSource(context.Background(), testTiles("jpg"), 1, 0, 0, 0, 0, 100, 100, nil, opt); err == nil {
+		t.Error("MergeSource() did not return error for invalid tile size")
+	}
+	if _, err := MergeLayers(context.Background(), []Layer{NewLayer(testTiles("jpg"))}, 1, 0, 0, 0, 0, 100, 100, nil, opt); err == nil {
+		t.Error("MergeLayers() did not return error for invalid tile size")
+	}
+	if _, err := TilesGeoreference(jpgTiles(), 0, 0, 100, 100, opt); err == nil {
+		t.Error("TilesGeoreference() did not return error for invalid tile size")
+	}
+
+	view := View{Zoom: 1, Width: 100, Height: 100, TileSize: AutoTileSize}
+	if _, err := MergeView(context.Background(), testTiles("jpg"), view, nil); err == nil {
+		t.Error("MergeView() did not return error for view without tile size")
+	}
+}
+
+func Test_Merge_Size_Invalid(t *testing.T) {
+	if _, err := Merge(jpgTiles(), 0, 0, -600, 100, nil); err == nil {
+		t.Error("Merge() did not return error for negative width")
+	}
+	if _, err := MergeSource(context.Background(), testTiles("jpg"), 1, 0, 0, 0, 0, 100, -1, nil); err == nil {
+		t.Error("MergeSource() did not return error for negative height")
+	}
+
+	view := View{Zoom: 1, Width: -100, Height: 100, TileSize: TILE_SIZE}
+	if _, err := MergeView(context.Background(), testTiles("jpg"), view, nil); err == nil {
+		t.Error("MergeView() did not return error for negative width")
+	}
+}
+
+func Test_MergeContext_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, workers := range []int{1, 4} {
+		_, err := MergeContext(ctx, jpgTiles(), 0, 0, 2*TILE_SIZE, 2*TILE_SIZE, nil, Workers(workers))
+		if err != context.Canceled {
+			t.Errorf("MergeContext() with %v workers returned error %v, expected %v", workers, err, context.Canceled)
+		}
+	}
+}
+
+// Canceling during merging should stop before the remaining tiles are requested
+func Test_MergeSource_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requested := 0
+	src := TileSourceFunc(func(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+		requested++
+		if requested == 2 {
+			cancel()
+		}
+		return testTiles("jpg").GetTile(context.Background(), z, x, y)
+	})
+
+	_, err := MergeSource(ctx, src, 1, 0, 0, 0, 0, 2*TILE_SIZE, 2*TILE_SIZE, nil)
+	if err != context.Canceled {
+		t.Errorf("MergeSource() returned error %v, expected %v", err, context.Canceled)
+	}
+	if requested != 2 {
+		t.Errorf("MergeSource() requested %v tiles after it was canceled, expected 2", requested)
+	}
+}
+
+func Test_Merge_Composite_Over(t *testing.T) {
+	tiles := loadTiles(4, 2, 5, 4, 6, "png")
+	width, height := mergedSize(tiles, TILE_SIZE)
+	img, err := Merge(tiles, 0, 0, width, height, color.RGBA{135, 206, 235, 255}, Composite(draw.Over))
+	if err != nil {
+		panic(err)
+	}
+
+	if *update {
+		exportPNG(img, "test_data/output/test_composite_over.png")
+	}
+
+	verifyDimensions(t, img, width, height)
+	verifyPNG(t, img, "test_data/output/test_composite_over.png")
+}
+
+// Transparent areas of tiles should show the background, and partially
+// transparent areas should be blended with it
+func Test_Merge_Composite_Alpha(t *testing.T) {
+	tile := image.NewNRGBA(image.Rect(0, 0, TILE_SIZE, TILE_SIZE))
+	tile.SetNRGBA(0, 0, color.NRGBA{255, 255, 255, 255}) // opaque
+	tile.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 0})   // transparent
+	tile.SetNRGBA(2, 0, color.NRGBA{255, 255, 255, 128}) // half transparent
+	tiles := Tiles{Tiles: []Tile{{Data: encodePNG(tile)}}}
+
+	cases := []struct {
+		bg       color.Color
+		op       draw.Op
+		expected []color.RGBA
+	}{
+		{color.RGBA{0, 0, 255, 255}, draw.Over, []color.RGBA{{255, 255, 255, 255}, {0, 0, 255, 255}, {128, 128, 255, 255}}},
+		{color.NRGBA{0, 0, 255, 128}, draw.Over, []color.RGBA{{255, 255, 255, 255}, {0, 0, 128, 128}, {128, 128, 192, 192}}},
+		{color.RGBA{0, 0, 255, 255}, draw.Src, []color.RGBA{{255, 255, 255, 255}, {0, 0, 0, 0}, {128, 128, 128, 128}}},
+	}
+
+	for _, c := range cases {
+		img, err := Merge(tiles, 0, 0, 3, 1, c.bg, Composite(c.op))
+		if err != nil {
+			panic(err)
+		}
+
+		rgba := img.(*image.RGBA)
+		for x, expected := range c.expected {
+			if actual := rgba.RGBAAt(x, 0); actual != expected {
+				t.Errorf("Merge() with bg %v and op %v: pixel %v = %v, expected %v", c.bg, c.op, x, actual, expected)
+			}
+		}
+	}
+}
+
+// Scaling with NearestNeighbor by 2 should repeat each pixel
+func Test_Merge_OutputScale_Nearest(t *testing.T) {
+	tiles := loadTiles(4, 2, 5, 4, 6, "png")
+	expected, err := Merge(tiles, 100, 50, 301, 201, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	img, err := Merge(tiles, 100, 50, 301, 201, nil, OutputScale(2, NearestNeighbor))
+	if err != nil {
+		panic(err)
+	}
+
+	verifyDimensions(t, img, 602, 402)
+	rgba := img.(*image.RGBA)
+	for y := 0; y < 402; y++ {
+		for x := 0; x < 602; x++ {
+			if rgba.RGBAAt(x, y) != expected.(*image.RGBA).RGBAAt(x/2, y/2) {
+				t.Fatalf("Merge() with OutputScale(2, NearestNeighbor) did not repeat pixel %v, %v", x/2, y/2)
+			}
+		}
+	}
+}
+
+func Test_Merge_OutputScale(t *testing.T) {
+	tiles := loadTiles(4, 2, 5, 4, 6, "png")
+	width, height := mergedSize(tiles, TILE_SIZE)
+
+	cases := []struct {
+		scale    float64
+		filter   Filter
+		filename string
+	}{
+		{1.5, CatmullRom, "test_output_scale_catmull_rom.png"},
+		{0.5, Lanczos, "test_output_scale_lanczos.png"},
+	}
+
+	for _, c := range cases {
+		img, err := Merge(tiles, 0, 0, width, height, color.White, OutputScale(c.scale, c.filter))
+		if err != nil {
+			panic(err)
+		}
+
+		if *update {
+			exportPNG(img, "test_data/output/"+c.filename)
+		}
+
+		verifyDimensions(t, img, int(float64(width)*c.scale), int(float64(height)*c.scale))
+		verifyPNG(t, img, "test_data/output/"+c.filename)
+	}
+}
+
+func Test_Merge_OutputScale_Invalid(t *testing.T) {
+	for _, scale := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		opt := OutputScale(scale, Bilinear)
+		if _, err := Merge(jpgTiles(), 0, 0, 100, 100, nil, opt); err == nil {
+			t.Errorf("Merge() did not return error for output scale %v", scale)
+		}
+		if _, err := MergeViewport(context.Background(), testTiles("png"), -98.5, 39.8, 4, 320, 240, nil, opt); err == nil {
+			t.Errorf("MergeViewport() did not return error for output scale %v", scale)
+		}
+	}
+}
+
+// Scaled views cover the same area with more pixels
+func Test_MergeViewport_OutputScale(t *testing.T) {
+	expected, err := MergeViewport(context.Background(), testTiles("png"), -98.5, 39.8, 4, 320, 240, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	img, err := MergeViewport(context.Background(), testTiles("png"), -98.5, 39.8, 4, 320, 240, nil, OutputScale(2, Box))
+	if err != nil {
+		panic(err)
+	}
+
+	verifyDimensions(t, img, 640, 480)
+
+	// scaling back down with a box filter should give the original pixels
+	down := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	Box.interpolator().Scale(down, down.Bounds(), img, img.Bounds(), draw.Src, nil)
+	if diff := maxDifference(down, expected); diff > 1 {
+		t.Errorf("MergeViewport() with OutputScale(2, Box) did not cover the same area: max difference %v", diff)
+	}
+}
@@ -0,0 +1,55 @@
+package tilemerge
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/brendan-ward/tilemerge/mercator"
+)
+
+// ViewportView returns the View displayed by a Leaflet map centered on
+// lon, lat at zoom, with a container that is width by height pixels.
+// The upper left corner is rounded to the nearest pixel, matching the pixel
+// origin of the Leaflet map.
+func ViewportView(lon, lat, zoom float64, width, height, tileSize int) (View, error) {
+	if tileSize <= 0 {
+		return View{}, errors.New("tilemerge: tile size must be known to merge by viewport")
+	}
+	if width <= 0 || height <= 0 {
+		return View{}, errors.New("tilemerge: viewport width and height must be greater than 0")
+	}
+	if zoom < 0 {
+		return View{}, errors.New("tilemerge: zoom must not be negative")
+	}
+	if zoom > math.MaxUint8 {
+		return View{}, errors.New("tilemerge: zoom must not be greater than 255")
+	}
+
+	x, y := mercator.Project(lon, lat, zoom, tileSize)
+
+	return View{
+		Zoom:     zoom,
+		X:        int(math.Floor(x - float64(width)/2 + 0.5)),
+		Y:        int(math.Floor(y - float64(height)/2 + 0.5)),
+		Width:    width,
+		Height:   height,
+		TileSize: tileSize,
+	}, nil
+}
+
+// MergeViewport merges the tiles from src displayed by a Leaflet map
+// centered on lon, lat at zoom, with a container that is width by height pixels.
+// Fractional zoom levels are rendered from tiles at the nearest integer zoom
+// level, or the zoom level set by the TileZoom option, scaled to fit.
+// The tile size must be known in advance; it is TILE_SIZE unless set with
+// the TileSize option.
+func MergeViewport(ctx context.Context, src TileSource, lon, lat, zoom float64, width, height int, bg color.Color, opts ...Option) (image.Image, error) {
+	view, err := ViewportView(lon, lat, zoom, width, height, newOptions(opts).tileSize)
+	if err != nil {
+		return nil, err
+	}
+	return MergeView(ctx, src, view, bg, opts...)
+}
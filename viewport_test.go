@@ -0,0 +1,161 @@
+package tilemerge
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"testing"
+)
+
+// Pixel origins are from Leaflet's map.getPixelOrigin() for a map with the
+// same center, zoom and container size
+func Test_ViewportView(t *testing.T) {
+	cases := []struct {
+		lon, lat, zoom float64
+		width, height  int
+		x, y           int
+	}{
+		{0, 0, 1, 512, 512, 0, 0},
+		{0, 0, 2, 800, 600, 112, 212},
+		{0, 0, 1.5, 400, 300, 162, 212},
+		{-100, 40, 4, 800, 600, 510, 1251},
+		{-98.5, 39.8, 4.25, 640, 481, 783, 1607},
+	}
+
+	for _, c := range cases {
+		view, err := ViewportView(c.lon, c.lat, c.zoom, c.width, c.height, TILE_SIZE)
+		if err != nil {
+			t.Errorf("ViewportView(%v, %v, %v, %v, %v) returned error: %v",
+				c.lon, c.lat, c.zoom, c.width, c.height, err)
+			continue
+		}
+
+		expected := View{Zoom: c.zoom, X: c.x, Y: c.y, Width: c.width, Height: c.height, TileSize: TILE_SIZE}
+		if view != expected {
+			t.Errorf("ViewportView(%v, %v, %v, %v, %v) = %+v, expected %+v",
+				c.lon, c.lat, c.zoom, c.width, c.height, view, expected)
+		}
+	}
+}
+
+func Test_ViewportView_Invalid(t *testing.T) {
+	if _, err := ViewportView(0, 0, 1, 0, 100, TILE_SIZE); err == nil {
+		t.Error("ViewportView() with 0 width did not return an error")
+	}
+	if _, err := ViewportView(0, 0, -1, 100, 100, TILE_SIZE); err == nil {
+		t.Error("ViewportView() with negative zoom did not return an error")
+	}
+	if _, err := ViewportView(0, 0, 300, 100, 100, TILE_SIZE); err == nil {
+		t.Error("ViewportView() with zoom greater than 255 did not return an error")
+	}
+	if _, err := ViewportView(0, 0, 1, 100, 100, AutoTileSize); err == nil {
+		t.Error("ViewportView() with unknown tile size did not return an error")
+	}
+}
+
+func Test_MergeViewport_Zoom_Invalid(t *testing.T) {
+	// tiles are requested by zoom levels from 0 to 255
+	if _, err := MergeViewport(context.Background(), testTiles("png"), 0, 0, 300, 10, 10, nil); err == nil {
+		t.Error("MergeViewport() with zoom 300 did not return an error")
+	}
+	view := View{Zoom: 255.5, Width: 10, Height: 10, TileSize: TILE_SIZE}
+	if _, err := MergeView(context.Background(), testTiles("png"), view, nil, TileZoom(CeilZoom)); err == nil {
+		t.Error("MergeView() with tiles at zoom 256 did not return an error")
+	}
+}
+
+// A viewport of the whole world should produce the same output as merging all tiles
+func Test_MergeViewport_World(t *testing.T) {
+	tiles := jpgTiles()
+	width, height := mergedSize(tiles, TILE_SIZE)
+	expected, err := Merge(tiles, 0, 0, width, height, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	img, err := MergeViewport(context.Background(), testTiles("jpg"), 0, 0, 1, width, height, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	verifyDimensions(t, img, width, height)
+	if !bytes.Equal(img.(*image.RGBA).Pix, expected.(*image.RGBA).Pix) {
+		t.Error("MergeViewport() did not match Merge() of all tiles")
+	}
+}
+
+func Test_MergeViewport_Fractional_Zoom(t *testing.T) {
+	img, err := MergeViewport(context.Background(), testTiles("png"), -98.5, 39.8, 4.25, 640, 481, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if *update {
+		exportPNG(img, "test_data/output/test_viewport_zoom_in.png")
+	}
+
+	verifyDimensions(t, img, 640, 481)
+	verifyPNG(t, img, "test_data/output/test_viewport_zoom_in.png")
+}
+
+func Test_MergeViewport_Fractional_Zoom_Out(t *testing.T) {
+	img, err := MergeViewport(context.Background(), testTiles("png"), -98.5, 39.8, 3.6, 300, 200, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	if *update {
+		exportPNG(img, "test_data/output/test_viewport_zoom_out.png")
+	}
+
+	verifyDimensions(t, img, 300, 200)
+	verifyPNG(t, img, "test_data/output/test_viewport_zoom_out.png")
+}
+
+func Test_MergeViewport_TileZoom(t *testing.T) {
+	// test_data has png tiles at zoom 4 only
+	cases := []struct {
+		zoom     float64
+		rounding ZoomRounding
+		filename string
+	}{
+		{4.75, FloorZoom, "test_viewport_floor_zoom.png"},
+		{3.25, CeilZoom, "test_viewport_ceil_zoom.png"},
+	}
+
+	for _, c := range cases {
+		img, err := MergeViewport(context.Background(), testTiles("png"), -98.5, 39.8, c.zoom, 640, 481, nil,
+			TileZoom(c.rounding), OutputScale(1, CatmullRom))
+		if err != nil {
+			panic(err)
+		}
+
+		if *update {
+			exportPNG(img, "test_data/output/"+c.filename)
+		}
+
+		verifyDimensions(t, img, 640, 481)
+		verifyPNG(t, img, "test_data/output/"+c.filename)
+	}
+}
+
+// Tiles from the zoom level above or below should line up in the same view
+func Test_MergeViewport_TileZoom_Aligned(t *testing.T) {
+	// tiles at zoom 3 are built from those at zoom 4
+	floor, err := MergeViewport(context.Background(), testTiles("png"), -98.5, 39.8, 3.5, 640, 481, nil,
+		TileZoom(FloorZoom), Underzoom(Box))
+	if err != nil {
+		panic(err)
+	}
+
+	ceil, err := MergeViewport(context.Background(), testTiles("png"), -98.5, 39.8, 3.5, 640, 481, nil,
+		TileZoom(CeilZoom))
+	if err != nil {
+		panic(err)
+	}
+
+	// zoom 3 tiles are blurrier, but features should be in the same place
+	if diff := meanDifference(floor, ceil); diff > 1 {
+		t.Errorf("tiles from zoom 3 and 4 did not line up: mean difference %v", diff)
+	}
+}
@@ -0,0 +1,38 @@
+// Package webp registers a WebP encoder for tilemerge.Encode, backed by
+// libwebp through cgo.  It is a separate package so that tilemerge itself
+// does not need cgo; import it for its side effect:
+//
+//	import _ "github.com/brendan-ward/tilemerge/webp"
+//
+// github.com/chai2010/webp, which wraps libwebp, also registers its own WebP
+// decoder with the image package, so image.Decode may use it instead of
+// golang.org/x/image/webp once this package is imported.
+package webp
+
+import (
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/brendan-ward/tilemerge"
+	libwebp "github.com/chai2010/webp"
+)
+
+func init() {
+	tilemerge.RegisterWebPEncoder(Encode)
+}
+
+// Encode writes img to w as WebP, compressed losslessly or at quality from
+// 1 to 100
+func Encode(w io.Writer, img image.Image, lossless bool, quality int) error {
+	// libwebp is passed the pixels of *image.RGBA as they are, but expects
+	// colors that are not premultiplied by alpha
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(b)
+	draw.Draw(nrgba, b, img, b.Min, draw.Src)
+	return libwebp.Encode(w, &image.RGBA{Pix: nrgba.Pix, Stride: nrgba.Stride, Rect: nrgba.Rect}, &libwebp.Options{
+		Lossless: lossless,
+		Quality:  float32(quality),
+		Exact:    true,
+	})
+}
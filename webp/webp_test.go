@@ -0,0 +1,104 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"os"
+	"testing"
+
+	_ "image/png" // load png decoder
+
+	"github.com/brendan-ward/tilemerge"
+	xwebp "golang.org/x/image/webp"
+)
+
+// loadImage loads the image at path as *image.RGBA
+func loadImage(path string) *image.RGBA {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		panic(err)
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// decode decodes WebP images with golang.org/x/image/webp, since the decoder
+// registered by libwebp returns colors that are not premultiplied by alpha
+func decode(t *testing.T, data []byte) *image.RGBA {
+	img, err := xwebp.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+func maxDifference(a, b *image.RGBA) int {
+	max := 0
+	for i := range a.Pix {
+		d := int(a.Pix[i]) - int(b.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+func Test_Encode(t *testing.T) {
+	img := loadImage("../test_data/4_3_5.png")
+
+	// the encoder is registered with tilemerge by importing this package
+	var buf bytes.Buffer
+	if err := tilemerge.Encode(&buf, img, tilemerge.WEBP, tilemerge.WebPLossless(true)); err != nil {
+		t.Fatal(err)
+	}
+	decoded := decode(t, buf.Bytes())
+	if decoded.Bounds() != img.Bounds() {
+		t.Fatalf("lossless WebP is %v, expected %v", decoded.Bounds(), img.Bounds())
+	}
+	if diff := maxDifference(decoded, img); diff != 0 {
+		t.Errorf("lossless WebP differs from image by %v", diff)
+	}
+
+	var low, high bytes.Buffer
+	if err := tilemerge.Encode(&low, img, tilemerge.WEBP, tilemerge.WebPQuality(20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tilemerge.Encode(&high, img, tilemerge.WEBP, tilemerge.WebPQuality(95)); err != nil {
+		t.Fatal(err)
+	}
+	if low.Len() >= high.Len() {
+		t.Errorf("WebP at quality 20 is %v bytes, expected less than %v at quality 95", low.Len(), high.Len())
+	}
+}
+
+func Test_Encode_Alpha(t *testing.T) {
+	// partly transparent pixels keep their color in lossless WebP
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(img.Pix); i += 4 {
+		a := uint8(i / 4)
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = a/2, a/4, a/8, a
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, true, 75); err != nil {
+		t.Fatal(err)
+	}
+
+	// colors are stored without premultiplied alpha, so may round differently
+	if diff := maxDifference(decode(t, buf.Bytes()), img); diff > 1 {
+		t.Errorf("lossless WebP with alpha differs from image by %v", diff)
+	}
+}
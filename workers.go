@@ -0,0 +1,76 @@
+package tilemerge
+
+import (
+	"context"
+	"image"
+	"sync"
+)
+
+// forEachTile calls fn for each tile, using up to workers goroutines.
+// Tiles are processed in order if workers is 1 or less.
+// The first error returned by fn cancels the context passed to the remaining
+// calls, and is returned once all calls in progress have completed.
+// If ctx is canceled, no further calls are made and ctx.Err() is returned.
+func forEachTile(ctx context.Context, tiles []image.Point, workers int, fn func(context.Context, image.Point) error) error {
+	if workers <= 1 {
+		for _, tile := range tiles {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(ctx, tile); err != nil {
+				if ctx.Err() != nil {
+					// err was caused by canceling ctx
+					return ctx.Err()
+				}
+				return err
+			}
+		}
+		return nil
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	jobs := make(chan image.Point)
+	for i := 0; i < workers && i < len(tiles); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tile := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := fn(ctx, tile); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+send:
+	for _, tile := range tiles {
+		select {
+		case jobs <- tile:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if parent.Err() != nil {
+		// tiles may have been skipped, or failed, because ctx was canceled
+		return parent.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,67 @@
+package tilemerge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// Merging with several workers should produce the same output as merging serially
+func Test_Merge_Workers(t *testing.T) {
+	cases := []struct {
+		tiles                     Tiles
+		xOff, yOff, width, height int
+		bg                        color.Color
+	}{
+		{jpgTiles(), 0, 0, 512, 512, nil},
+		{jpgTiles(), 100, 50, 337, 417, nil},
+		{jpgTiles(), -100, -50, 687, 687, color.RGBA{255, 0, 0, 255}},
+		{loadTiles(4, 2, 5, 4, 6, "png"), 0, 0, 768, 512, nil},
+		{loadTiles(4, 3, 5, 4, 6, "webp"), 10, 20, 400, 300, color.RGBA{0, 0, 255, 255}},
+	}
+
+	for i, c := range cases {
+		expected, err := Merge(c.tiles, c.xOff, c.yOff, c.width, c.height, c.bg)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, workers := range []int{2, 4, 16} {
+			img, err := Merge(c.tiles, c.xOff, c.yOff, c.width, c.height, c.bg, Workers(workers))
+			if err != nil {
+				panic(err)
+			}
+
+			if !bytes.Equal(img.(*image.RGBA).Pix, expected.(*image.RGBA).Pix) {
+				t.Errorf("case %v: Merge() with %v workers did not match serial output", i, workers)
+			}
+		}
+	}
+}
+
+// A decode error should cancel the tiles that have not yet been merged
+func Test_Merge_Workers_Error(t *testing.T) {
+	invalid := []byte("not an image")
+	src := TileSourceFunc(func(ctx context.Context, z uint8, x, y int) ([]byte, error) {
+		if x == 0 && y == 0 {
+			return invalid, nil
+		}
+
+		// other tiles wait until they are canceled
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("tile was not canceled")
+		}
+	})
+
+	_, err := MergeSource(context.Background(), src, 4, 0, 0, 0, 0, 1024, 1024, nil, Workers(4))
+	if err != image.ErrFormat {
+		t.Errorf("MergeSource() returned error %v, expected %v", err, image.ErrFormat)
+	}
+}